@@ -1,11 +1,10 @@
-package main
+package numen
 
 import (
 	"fmt"
 )
 
 type IStack []PToken
-type IMemory map[string]PToken
 
 // PopAny pops the last item from the stack
 func (s *IStack) PopAny() PToken {
@@ -20,7 +19,7 @@ func (s *IStack) PopAny() PToken {
 	return token
 }
 
-func (s *IStack) PopStack() IStack {
+func (s *IStack) PopStack() IList {
 	if len(*s) == 0 {
 		panic("[POP] PopStack called on an empty stack")
 	}
@@ -32,14 +31,14 @@ func (s *IStack) PopStack() IStack {
 	if token.Type != P_STACK {
 		panic(fmt.Sprintf("[POP] type mismatch: expected P_STACK, got %d", token.Type))
 	}
-	if v, ok := token.Value.(IStack); ok {
+	if v, ok := token.Value.(IList); ok {
 		return v
 	}
 
 	panic("[POP] failed to cast value to Stack")
 }
 
-func (s *IStack) PopBlock() string {
+func (s *IStack) PopBlock() IBlock {
 	if len(*s) == 0 {
 		panic("[POP] PopBlock called on an empty stack")
 	}
@@ -51,11 +50,11 @@ func (s *IStack) PopBlock() string {
 	if token.Type != P_BLOCK {
 		panic(fmt.Sprintf("[POP] type mismatch: expected P_BLOCK, got %d", token.Type))
 	}
-	if v, ok := token.Value.(string); ok {
+	if v, ok := token.Value.(IBlock); ok {
 		return v
 	}
 
-	panic("[POP] failed to cast value to Block/String")
+	panic("[POP] failed to cast value to Block")
 }
 
 // PopInt pops the last item from the stack, ensures it's an integer, and returns it.
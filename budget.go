@@ -0,0 +1,161 @@
+package numen
+
+import (
+	"context"
+	"fmt"
+)
+
+// Limits on a single interpreter, zero means unlimited. Going over one
+// raises a NumenError whose kind names the limit, scripts can catch it.
+type Budget struct {
+	MaxSteps  int64 // words run and blocks entered
+	MaxStack  int   // items on globalStack
+	MaxDepth  int   // nested run_function calls
+	MaxMemory int64 // approximate bytes held by the stack and scopes
+}
+
+// DEFAULT_MAX_DEPTH keeps deep recursion from overflowing the Go stack
+const DEFAULT_MAX_DEPTH = 10000
+
+// memory use is only measured every this many steps
+const memory_check_interval = 1024
+
+// the context is only polled every this many steps
+const context_check_interval = 64
+
+func WithMaxSteps(steps int64) Option {
+	return func(in *Interpreter) {
+		in.Budget.MaxSteps = steps
+	}
+}
+
+func WithMaxStack(items int) Option {
+	return func(in *Interpreter) {
+		in.Budget.MaxStack = items
+	}
+}
+
+func WithMaxDepth(depth int) Option {
+	return func(in *Interpreter) {
+		in.Budget.MaxDepth = depth
+	}
+}
+
+func WithMaxMemory(bytes int64) Option {
+	return func(in *Interpreter) {
+		in.Budget.MaxMemory = bytes
+	}
+}
+
+// WithContext stops the script once ctx is done, for example on a timeout
+func WithContext(ctx context.Context) Option {
+	return func(in *Interpreter) {
+		in.Context = ctx
+	}
+}
+
+func limit_error(kind string, format string, args ...any) *NumenError {
+	return new_error(kind, currentPos, fmt.Sprintf(format, args...))
+}
+
+// step is called before every word and when a block is entered
+func (in *Interpreter) step() {
+	in.steps++
+	if in.Budget.MaxSteps > 0 && in.steps > in.Budget.MaxSteps {
+		panic(limit_error("step-limit", "[LIMIT] step limit of %v reached", in.Budget.MaxSteps))
+	}
+	if in.Context != nil && in.steps%context_check_interval == 0 {
+		in.check_context()
+	}
+	if in.Budget.MaxMemory > 0 && in.steps%memory_check_interval == 0 {
+		if used := estimate_memory(); used > in.Budget.MaxMemory {
+			panic(limit_error("memory-limit", "[LIMIT] memory limit of %v bytes reached, using about %v", in.Budget.MaxMemory, used))
+		}
+	}
+}
+
+func (in *Interpreter) check_context() {
+	if err := in.Context.Err(); err != nil {
+		kind := "cancelled"
+		if err == context.DeadlineExceeded {
+			kind = "timeout"
+		}
+		panic(limit_error(kind, "[LIMIT] %v", err))
+	}
+}
+
+// check_stack is called after every word. Between full memory checks the
+// top of the stack is measured shallowly, so a single value growing fast
+// is caught early.
+func (in *Interpreter) check_stack() {
+	if in.Budget.MaxStack > 0 && len(globalStack) > in.Budget.MaxStack {
+		panic(limit_error("stack-limit", "[LIMIT] stack limit of %v items reached", in.Budget.MaxStack))
+	}
+	if in.Budget.MaxMemory > 0 && len(globalStack) > 0 {
+		if used := shallow_size(globalStack[len(globalStack)-1]); used > in.Budget.MaxMemory {
+			panic(limit_error("memory-limit", "[LIMIT] memory limit of %v bytes reached, using about %v", in.Budget.MaxMemory, used))
+		}
+	}
+}
+
+// enter is called when run_function starts, the returned func when it ends
+func (in *Interpreter) enter() func() {
+	// entering a block is a step, so even { } loop runs out of steps
+	in.step()
+	in.depth++
+	if in.Budget.MaxDepth > 0 && in.depth > in.Budget.MaxDepth {
+		in.depth--
+		panic(limit_error("depth-limit", "[LIMIT] depth limit of %v nested calls reached", in.Budget.MaxDepth))
+	}
+	return func() {
+		in.depth--
+	}
+}
+
+// estimate_memory approximates the bytes held by the stack and the visible
+// scopes. Shared structure is counted every time it is reachable.
+func estimate_memory() (total int64) {
+	for _, token := range globalStack {
+		total += token_size(token)
+	}
+	counted := map[*IScope]bool{}
+	for _, scope := range []*IScope{currentScope, globalScope} {
+		for ; scope != nil && !counted[scope]; scope = scope.parent {
+			counted[scope] = true
+			for name, token := range scope.vars {
+				total += int64(len(name)) + token_size(token)
+			}
+		}
+	}
+	return total
+}
+
+const token_overhead = 32
+
+// shallow_size is token_size without looking into nested values
+func shallow_size(token PToken) int64 {
+	if token.Type == P_STACK {
+		return int64(token_overhead * (1 + token.Value.(IList).Len()))
+	} else if token.Type == P_MEMORY {
+		return int64(token_overhead * (1 + token.Value.(IMemory).Len()))
+	}
+	return token_size(token)
+}
+
+func token_size(token PToken) int64 {
+	size := int64(token_overhead)
+	if Contains(token.Type, P_STRING, P_SYMBOL) {
+		size += int64(len(token.Value.(string)))
+	} else if token.Type == P_BLOCK {
+		size += int64(len(token.Value.(IBlock).Code))
+	} else if token.Type == P_STACK {
+		token.Value.(IList).Each(func(_ int, item PToken) {
+			size += token_size(item)
+		})
+	} else if token.Type == P_MEMORY {
+		token.Value.(IMemory).Each(func(key string, value PToken) {
+			size += int64(len(key)) + token_size(value)
+		})
+	}
+	return size
+}
@@ -0,0 +1,28 @@
+package numen
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStepLimitStopsEmptyBodies(t *testing.T) {
+	for _, code := range []string{`{ } loop`, `{ { } run } loop`, `: spin ; { spin } loop`} {
+		_, err := NewInterpreter(WithMaxSteps(100)).Run("<test>", code)
+		var numen_err *NumenError
+		if !errors.As(err, &numen_err) || numen_err.Kind != "step-limit" {
+			t.Errorf("%v with 100 steps gave %v, want a step-limit error", code, err)
+		}
+	}
+}
+
+func TestContextStopsEmptyLoop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := NewInterpreter(WithContext(ctx)).Run("<test>", `{ } loop`)
+	var numen_err *NumenError
+	if !errors.As(err, &numen_err) || numen_err.Kind != "timeout" {
+		t.Errorf("{ } loop with a timeout gave %v, want a timeout error", err)
+	}
+}
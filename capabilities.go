@@ -0,0 +1,91 @@
+package numen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Capability names a group of builtins an embedder can allow or deny
+type Capability string
+
+const (
+	Core    Capability = "core"    // stack, variables, memories, control flow, words and errors
+	Math    Capability = "math"    // arithmetic, ordering and hashing
+	Strings Capability = "strings" // formatting, repr, parsing, json and path helpers
+	IO      Capability = "io"      // printing and reading stdin
+	FS      Capability = "fs"      // files under the sandbox root
+	Modules Capability = "modules" // import
+	Debug   Capability = "debug"   // debug output and breakpoints
+)
+
+var AllCapabilities = []Capability{Core, Math, Strings, IO, FS, Modules, Debug}
+
+// builtinCapability maps every builtin to the capability it needs
+var builtinCapability = map[string]Capability{}
+
+// builtins from the main table that are not core
+var capabilityGroups = map[Capability][]string{
+	Math:    {"+", "-", "*", "/", "<", ">", "<=", ">=", "num==", "num!=", "hash"},
+	Strings: {"format", "repr", "parse", "tojson"},
+	IO:      {"print", "println", "printf", "readline", "readall", "readlines", "readnum", "eof?"},
+	Debug:   {"dbgprint"},
+}
+
+// register adds a group of builtins that all need capability
+func register(capability Capability, group map[string]func()) {
+	for name, builtin := range group {
+		builtins[name] = builtin
+		builtinCapability[name] = capability
+	}
+}
+
+// WithCapabilities allows only the builtins of the given capabilities
+func WithCapabilities(capabilities ...Capability) Option {
+	return func(in *Interpreter) {
+		in.Capabilities = map[Capability]bool{}
+		for _, capability := range capabilities {
+			in.Capabilities[capability] = true
+		}
+	}
+}
+
+// ParseCapabilities reads a comma separated list such as "core,math"
+func ParseCapabilities(list string) ([]Capability, error) {
+	var capabilities []Capability
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			capabilities = append(capabilities, AllCapabilities...)
+			continue
+		}
+		if !Contains(Capability(name), AllCapabilities...) {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+		capabilities = append(capabilities, Capability(name))
+	}
+	return capabilities, nil
+}
+
+// allow panics unless the builtin name may be used
+func (in *Interpreter) allow(name string) {
+	if in.Capabilities == nil {
+		return
+	}
+	capability := builtinCapability[name]
+	if !in.Capabilities[capability] {
+		panic(new_error("permission", currentPos, fmt.Sprintf("[%v] permission denied: %v", strings.ToUpper(name), capability)))
+	}
+}
+
+func capability_list() string {
+	names := make([]string, len(AllCapabilities))
+	for ix, capability := range AllCapabilities {
+		names[ix] = string(capability)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,873 @@
+package numen
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// numen check follows the stack depth through a script without running
+// it. Builtins have known stack effects, user words declare theirs with a
+// ( in -- out ) comment after the name, function memories with their
+// params and returns keys, and the effect of other words is inferred from
+// their body. A word whose effect cannot be known, such as running a block
+// held in a variable, ends what the checker can say about the depth until
+// its block ends. With -types the checker also follows value types, see
+// checktypes.go.
+
+// Diagnostic is a problem found without running the script
+type Diagnostic struct {
+	Pos     Position
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%v: %v", d.Pos, d.Message)
+}
+
+// stackEffect lists what a word takes and leaves, bottom first
+type stackEffect struct {
+	in  []string
+	out []string
+}
+
+func (effect stackEffect) String() string {
+	parts := append([]string{"("}, effect.in...)
+	parts = append(append(parts, "--"), effect.out...)
+	return strings.Join(append(parts, ")"), " ")
+}
+
+// parse_effect reads "a b -- c"
+func parse_effect(spec string) stackEffect {
+	in, out, _ := strings.Cut(spec, "--")
+	return stackEffect{strings.Fields(in), strings.Fields(out)}
+}
+
+// effect_annotation reads a ( a b -- c ) stack literal, the names are
+// symbols or type literals
+func effect_annotation(token PToken) (stackEffect, bool) {
+	if token.Type != P_STACK {
+		return stackEffect{}, false
+	}
+	var effect stackEffect
+	separators := 0
+	ok := true
+	token.Value.(IList).Each(func(_ int, item PToken) {
+		var name string
+		if item.Type == P_SYMBOL {
+			name = item.Value.(string)
+		} else if item.Type == P_TYPE_LITERAL {
+			name = item.Value.(TypeLiterals).String()
+		} else {
+			ok = false
+			return
+		}
+		if name == "--" {
+			separators++
+		} else if separators == 0 {
+			effect.in = append(effect.in, name)
+		} else {
+			effect.out = append(effect.out, name)
+		}
+	})
+	return effect, ok && separators == 1
+}
+
+// builtinEffects are the effects of builtins that only move values. Names
+// of types, such as int or str|symbol, are the types a word takes and
+// leaves. Any other output named like an input is that same value. Control
+// flow, arithmetic, variables and formatting are handled by the checker
+// itself.
+var builtinEffects = map[string]stackEffect{}
+
+func init() {
+	for name, spec := range map[string]string{
+		"dbgprint": "a -- a", "print": "a --", "println": "a --",
+		"readline": "-- line", "readall": "-- str", "readlines": "-- stack", "readnum": "-- number",
+		"eof?": "a -- a bool", "repr": "a -- str", "parse": "str|symbol -- value",
+		"push": "value stack -- stack", "pop": "stack -- stack value",
+		"swap": "a b -- b a", "rot": "a b c -- b c a", "dup": "a -- a a", "drop": "a --", "over": "a b -- a b a",
+		"storeto": "value symbol memory|symbol -- memory", "loadfrom": "symbol memory|symbol -- value",
+		"<": "num num -- bool", ">": "num num -- bool", "<=": "num num -- bool", ">=": "num num -- bool",
+		"==": "a b -- bool", "!=": "a b -- bool", "num==": "a b -- bool", "num!=": "a b -- bool",
+		"hash": "a -- int", "len": "a -- a int", "keys": "memory -- stack", "tojson": "a -- str",
+		"readfile": "str|symbol -- str", "writefile": "str|symbol str|symbol --", "appendfile": "str|symbol str|symbol --",
+		"exists?": "str|symbol -- bool", "listdir": "str|symbol -- stack", "mkdir": "str|symbol --",
+		"remove": "str|symbol --", "glob": "str|symbol -- stack",
+		"pathjoin": "str|symbol str|symbol -- str", "basename": "str|symbol -- str",
+		"dirname": "str|symbol -- str", "ext": "str|symbol -- str",
+		"forget": "str|symbol --", "assert": "bool --", "assert-eq": "actual expected --", "assert-stack": "stack --",
+		"breakpoint": "--",
+	} {
+		builtinEffects[name] = parse_effect(spec)
+	}
+}
+
+// checkValue is a value on the checker's stack, literals are known
+type checkValue struct {
+	token PToken
+	known bool
+	types typeSet // the types it may have, zero for any
+}
+
+func (v checkValue) same(other checkValue) bool {
+	return v.known && other.known && v.token.Type == other.token.Type && v.token.Equal(other.token)
+}
+
+// checkState is the stack as the checker sees it at one point of a block
+type checkState struct {
+	items []checkValue // pushed above the base, top last
+	taken int          // values taken from below the base
+	floor int          // values below the base, -1 when any number may be there
+	lost  bool         // the depth is unknown since a word with an unknown effect
+	ended bool         // break or throw left the block, the rest never runs
+
+	inputs []checkValue // declared values below the base, bottom first
+}
+
+func (s *checkState) depth() int {
+	return len(s.items) - s.taken
+}
+
+// fork copies the state for a block that may or may not run
+func (s *checkState) fork() *checkState {
+	return &checkState{items: append([]checkValue(nil), s.items...), taken: s.taken, floor: s.floor, inputs: s.inputs}
+}
+
+func (s *checkState) push(value checkValue) {
+	s.items = append(s.items, value)
+}
+
+func (s *checkState) pop() checkValue {
+	if len(s.items) == 0 {
+		s.taken++
+		return s.below(s.taken)
+	}
+	value := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return value
+}
+
+// below is the n-th value below the base, counting from 1
+func (s *checkState) below(n int) checkValue {
+	if n > len(s.inputs) {
+		return checkValue{}
+	}
+	return s.inputs[len(s.inputs)-n]
+}
+
+// from_top is the k-th value from the top, counting from 1
+func (s *checkState) from_top(k int) checkValue {
+	if k > len(s.items) {
+		return s.below(s.taken + k - len(s.items))
+	}
+	return s.items[len(s.items)-k]
+}
+
+// lose forgets the stack after a word with an unknown effect
+func (s *checkState) lose() {
+	s.items, s.taken, s.floor, s.lost, s.inputs = nil, 0, -1, true, nil
+}
+
+// merge joins two states of the same depth, values that differ become
+// unknown values of either type
+func merge(a *checkState, b *checkState) *checkState {
+	taken := max(a.taken, b.taken)
+	size := a.depth() + taken
+	merged := &checkState{items: make([]checkValue, size), taken: taken, floor: a.floor, lost: a.lost || b.lost, inputs: a.inputs}
+	if a.floor != b.floor {
+		merged.floor = -1
+	}
+	for k := 1; k <= size; k++ {
+		first, second := a.from_top(k), b.from_top(k)
+		if first.same(second) {
+			merged.items[size-k] = first
+		} else {
+			merged.items[size-k] = typed(first.type_set() | second.type_set())
+		}
+	}
+	return merged
+}
+
+// checkWord is a word defined with : ; def or override
+type checkWord struct {
+	name     string
+	pos      Position
+	body     []PWord
+	declared *stackEffect
+	effect   *stackEffect // nil while unknown
+	checking bool
+	done     bool
+}
+
+// checkFunction is a function memory with a params key
+type checkFunction struct {
+	effect *stackEffect // nil while unknown, also while it is being checked
+}
+
+// checkLoop collects the states break leaves a loop with
+type checkLoop struct {
+	exits     []*checkState
+	positions []Position
+}
+
+// CheckOptions configures numen check
+type CheckOptions struct {
+	Types bool // also check the types of values
+}
+
+type checker struct {
+	options     CheckOptions
+	diagnostics []Diagnostic
+	seen        map[Diagnostic]bool
+	words       map[string]*checkWord
+	vars        map[string]checkValue // stored values by name, scopes are not told apart
+	functions   map[Position]*checkFunction
+	loops       []*checkLoop
+	parsed      map[Position][]PWord
+	active      map[Position]bool // blocks being checked, recursion stops there
+	checked     map[Position]bool // blocks checked at least once
+	pending     []IBlock          // blocks seen as literals
+}
+
+func new_checker(options CheckOptions) *checker {
+	return &checker{
+		options:   options,
+		seen:      map[Diagnostic]bool{},
+		words:     map[string]*checkWord{},
+		vars:      map[string]checkValue{},
+		functions: map[Position]*checkFunction{},
+		parsed:    map[Position][]PWord{},
+		active:    map[Position]bool{},
+		checked:   map[Position]bool{},
+	}
+}
+
+func (c *checker) report(pos Position, format string, args ...any) {
+	diagnostic := Diagnostic{pos, fmt.Sprintf(format, args...)}
+	if !c.seen[diagnostic] {
+		c.seen[diagnostic] = true
+		c.diagnostics = append(c.diagnostics, diagnostic)
+	}
+}
+
+// parse_words reads all words of code with their positions
+func parse_words(code string, start Position) (words []PWord) {
+	interp_chan, wait := parse_async(code, start)
+	for word := range interp_chan {
+		words = append(words, word)
+	}
+	if err := wait(); err != nil {
+		panic(err)
+	}
+	return words
+}
+
+func (c *checker) parse(block IBlock) []PWord {
+	words, ok := c.parsed[block.Pos]
+	if !ok {
+		words = parse_words(block.Code, block.Pos)
+		c.parsed[block.Pos] = words
+	}
+	return words
+}
+
+// CheckFile checks the stack effects of the script at path
+func CheckFile(path string, options CheckOptions) ([]Diagnostic, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return check_code(string(source), Position{path, 1, 1}, options)
+}
+
+func check_code(code string, start Position, options CheckOptions) ([]Diagnostic, error) {
+	c := new_checker(options)
+	if err := protect(func() {
+		words := parse_words(code, start)
+		c.collect(words)
+		c.run_words(words, &checkState{})
+		c.check_rest()
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(c.diagnostics, func(a, b int) bool {
+		return before(c.diagnostics[a].Pos, c.diagnostics[b].Pos)
+	})
+	return c.diagnostics, nil
+}
+
+// check_rest checks the words and blocks the script itself never reached
+func (c *checker) check_rest() {
+	words := make([]*checkWord, 0, len(c.words))
+	for _, word := range c.words {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(a, b int) bool {
+		return before(words[a].pos, words[b].pos)
+	})
+	for _, word := range words {
+		c.word_effect(word)
+	}
+	for ix := 0; ix < len(c.pending); ix++ {
+		if block := c.pending[ix]; !c.checked[block.Pos] {
+			c.run_block(block, &checkState{floor: -1})
+		}
+	}
+}
+
+// collect finds the words code defines, also inside blocks
+func (c *checker) collect(words []PWord) {
+	for ix, word := range words {
+		if word.Type == P_BLOCK {
+			c.collect(c.parse(word.Value.(IBlock)))
+		}
+		if word.Type != P_SYMBOL || word.Quoted {
+			continue
+		}
+		if word.Value == ":" && ix+1 < len(words) && words[ix+1].Type == P_SYMBOL {
+			definition := &checkWord{name: words[ix+1].Value.(string), pos: words[ix+1].Pos}
+			start := ix + 2
+			if start < len(words) {
+				if effect, ok := effect_annotation(words[start].PToken); ok {
+					definition.declared = &effect
+					start++
+				}
+			}
+			end := start
+			for end < len(words) && !(words[end].Type == P_SYMBOL && !words[end].Quoted && words[end].Value == ";") {
+				end++
+			}
+			definition.body = words[start:end]
+			c.words[definition.name] = definition
+		} else if (word.Value == "def" || word.Value == "override") && ix >= 2 &&
+			words[ix-2].Type == P_BLOCK && words[ix-1].Type == P_SYMBOL {
+			// { body } 'name def
+			block := words[ix-2].Value.(IBlock)
+			name := words[ix-1]
+			c.words[name.Value.(string)] = &checkWord{name: name.Value.(string), pos: name.Pos, body: c.parse(block)}
+			c.checked[block.Pos] = true
+		}
+	}
+}
+
+// run_words follows words on the stack s, definitions are skipped
+func (c *checker) run_words(words []PWord, s *checkState) {
+	for ix := 0; ix < len(words) && !s.ended; ix++ {
+		word := words[ix]
+		if word.Type == P_SYMBOL && !word.Quoted && word.Value == ":" {
+			for ix < len(words) && !(words[ix].Type == P_SYMBOL && !words[ix].Quoted && words[ix].Value == ";") {
+				ix++
+			}
+			continue
+		}
+		c.run_word(word, s)
+	}
+}
+
+func (c *checker) run_block(block IBlock, s *checkState) {
+	if c.active[block.Pos] {
+		s.lose()
+		return
+	}
+	c.active[block.Pos] = true
+	defer delete(c.active, block.Pos)
+	c.checked[block.Pos] = true
+	c.run_words(c.parse(block), s)
+}
+
+// take pops n values for name, reporting when the stack cannot hold them
+func (c *checker) take(s *checkState, n int, name string, pos Position) []checkValue {
+	if available := len(s.items) - s.taken + s.floor; s.floor >= 0 && available < n {
+		c.report(pos, "[UNDERFLOW] %v takes %v, the stack holds %v", name, count(n, "item"), available)
+		// one report is enough, the depth is wrong from here on
+		s.floor, s.lost = -1, true
+	}
+	values := make([]checkValue, n)
+	for ix := n - 1; ix >= 0; ix-- {
+		values[ix] = s.pop()
+	}
+	return values
+}
+
+// apply runs a word with a known effect. Inputs named after types are
+// checked, outputs named after types get them. With same set, other
+// outputs named like an input are that input.
+func (c *checker) apply(s *checkState, effect stackEffect, same bool, name string, pos Position) {
+	values := c.take(s, len(effect.in), name, pos)
+	for ix, input := range effect.in {
+		if types, ok := parse_types(input); ok {
+			c.expect(values[ix], types, name, pos)
+		}
+	}
+	for _, output := range effect.out {
+		value := checkValue{}
+		if types, ok := parse_types(output); ok {
+			value = typed(types)
+		} else if same {
+			for ix, input := range effect.in {
+				if input == output {
+					value = values[ix]
+				}
+			}
+		}
+		s.push(value)
+	}
+}
+
+func (c *checker) run_word(word PWord, s *checkState) {
+	if word.Type != P_SYMBOL || word.Quoted {
+		c.literal(word.PToken)
+		s.push(literal_value(word.PToken))
+		return
+	}
+	name := word.Value.(string)
+	if definition, ok := c.words[name]; ok {
+		if effect := c.word_effect(definition); effect != nil {
+			c.apply(s, *effect, false, name, word.Pos)
+		} else {
+			s.lose()
+		}
+		return
+	}
+	if _, ok := builtins[name]; !ok {
+		// a variable, or a symbol that pushes itself
+		if value, ok := c.vars[name]; ok {
+			s.push(value)
+		} else if root, _, dotted := strings.Cut(name, "."); dotted && has_var(c.vars, root) {
+			s.push(checkValue{})
+		} else {
+			s.push(literal_value(word.PToken))
+		}
+		return
+	}
+	if effect, ok := builtinEffects[name]; ok {
+		c.apply(s, effect, true, name, word.Pos)
+		return
+	}
+
+	switch name {
+	case "+", "-", "*", "/":
+		values := c.take(s, 2, name, word.Pos)
+		s.push(typed(c.arithmetic(name, values[0], values[1], word.Pos)))
+	case "if":
+		values := c.take(s, 2, name, word.Pos)
+		c.expect(values[0], TL_BOOLEAN.types(), name, word.Pos)
+		block, ok := c.block(values[1], name, word.Pos)
+		if !ok {
+			s.lose()
+			return
+		}
+		branch := s.fork()
+		c.run_block(block, branch)
+		if branch.lost {
+			s.lose()
+		} else if !branch.ended && branch.depth() != s.depth() {
+			c.report(word.Pos, "[IF] the block leaves %v than skipping it", difference(branch.depth()-s.depth()))
+			s.lose()
+		} else if !branch.ended {
+			*s = *merge(s, branch)
+		}
+	case "loop":
+		block, ok := c.block(c.take(s, 1, name, word.Pos)[0], name, word.Pos)
+		if !ok {
+			s.lose()
+			return
+		}
+		loop := &checkLoop{}
+		c.loops = append(c.loops, loop)
+		body := s.fork()
+		c.run_block(block, body)
+		c.loops = c.loops[:len(c.loops)-1]
+		if !body.lost && !body.ended && body.depth() != s.depth() {
+			c.report(word.Pos, "[LOOP] every iteration leaves %v on the stack", difference(body.depth()-s.depth()))
+		}
+		if body.lost || len(loop.exits) == 0 {
+			// without a break of its own the loop ends by throwing, or never
+			s.lose()
+			return
+		}
+		exit := loop.exits[0]
+		for ix, other := range loop.exits[1:] {
+			if other.lost || exit.lost {
+				s.lose()
+				return
+			}
+			if other.depth() != exit.depth() {
+				first := loop.positions[0]
+				c.report(loop.positions[ix+1], "[BREAK] leaves %v than the break at %v:%v", difference(other.depth()-exit.depth()), first.Line, first.Col)
+				s.lose()
+				return
+			}
+			exit = merge(exit, other)
+		}
+		if exit.lost {
+			s.lose()
+			return
+		}
+		exit.lost = s.lost
+		*s = *exit
+	case "break":
+		if len(c.loops) > 0 {
+			loop := c.loops[len(c.loops)-1]
+			exit := s.fork()
+			exit.lost = s.lost
+			loop.exits = append(loop.exits, exit)
+			loop.positions = append(loop.positions, word.Pos)
+		}
+		s.ended = true
+	case "throw":
+		c.take(s, 1, name, word.Pos)
+		s.ended = true
+	case "run":
+		if block, ok := c.block(c.take(s, 1, name, word.Pos)[0], name, word.Pos); ok {
+			c.run_block(block, s)
+		} else {
+			s.lose()
+		}
+	case "runfrom":
+		values := c.take(s, 2, name, word.Pos)
+		c.expect(values[0], TL_MEMORY.types()|TL_SYMBOL.types(), name, word.Pos)
+		if block, ok := c.block(values[1], name, word.Pos); ok {
+			c.run_block(block, s)
+		} else {
+			s.lose()
+		}
+	case "call":
+		c.call(c.take(s, 1, name, word.Pos)[0], s, word.Pos)
+	case "try":
+		values := c.take(s, 2, name, word.Pos)
+		body, body_ok := c.block(values[0], name, word.Pos)
+		handler, handler_ok := c.block(values[1], name, word.Pos)
+		if !body_ok || !handler_ok {
+			s.lose()
+			return
+		}
+		tried := s.fork()
+		c.run_block(body, tried)
+		caught := s.fork()
+		caught.push(typed(TL_MEMORY.types()))
+		c.run_block(handler, caught)
+		if tried.lost || caught.lost {
+			s.lose()
+		} else if tried.ended && caught.ended {
+			s.ended = true
+		} else if tried.ended {
+			caught.lost = s.lost
+			*s = *caught
+		} else if caught.ended {
+			tried.lost = s.lost
+			*s = *tried
+		} else if tried.depth() != caught.depth() {
+			c.report(word.Pos, "[TRY] the body leaves %v than the handler", difference(tried.depth()-caught.depth()))
+			s.lose()
+		} else {
+			merged := merge(tried, caught)
+			merged.lost = s.lost
+			*s = *merged
+		}
+	case "finally":
+		values := c.take(s, 2, name, word.Pos)
+		body, body_ok := c.block(values[0], name, word.Pos)
+		cleanup, cleanup_ok := c.block(values[1], name, word.Pos)
+		if !body_ok || !cleanup_ok {
+			s.lose()
+			return
+		}
+		c.run_block(body, s)
+		if s.ended {
+			c.run_block(cleanup, s.fork())
+		} else {
+			c.run_block(cleanup, s)
+		}
+	case "assert-throws":
+		if block, ok := c.block(c.take(s, 1, name, word.Pos)[0], name, word.Pos); ok {
+			c.run_block(block, s.fork())
+		}
+	case "def", "override":
+		values := c.take(s, 2, name, word.Pos)
+		c.block(values[0], name, word.Pos)
+		c.expect(values[1], TL_STRING.types()|TL_SYMBOL.types(), name, word.Pos)
+	case "store", "set":
+		values := c.take(s, 2, name, word.Pos)
+		c.expect(values[1], TL_SYMBOL.types(), name, word.Pos)
+		if values[1].known && values[1].token.Type == P_SYMBOL {
+			c.store(values[1].token.Value.(string), values[0])
+		}
+	case "load":
+		value := c.take(s, 1, name, word.Pos)[0]
+		c.expect(value, TL_SYMBOL.types(), name, word.Pos)
+		if value.known && value.token.Type == P_SYMBOL {
+			s.push(c.vars[value.token.Value.(string)])
+		} else {
+			s.push(checkValue{})
+		}
+	case "import":
+		path := c.take(s, 1, name, word.Pos)[0]
+		c.expect(path, TL_STRING.types()|TL_SYMBOL.types(), name, word.Pos)
+		if path.known && path.token.Type == P_STRING {
+			c.store(module_name(path.token.Value.(string)), typed(TL_MEMORY.types()))
+		}
+	case "printf", "format":
+		format := c.take(s, 1, name, word.Pos)[0]
+		c.expect(format, TL_STRING.types()|TL_SYMBOL.types(), name, word.Pos)
+		if !format.known || format.token.Type != P_STRING {
+			s.lose()
+			return
+		}
+		var parts []formatPart
+		if err := protect(func() {
+			parts = parse_format(format.token.Value.(string))
+		}); err != nil {
+			c.report(word.Pos, "%v", err.(*NumenError).Message)
+			s.lose()
+			return
+		}
+		var verbs []rune
+		for _, part := range parts {
+			if part.verb != 0 {
+				verbs = append(verbs, part.verb)
+			}
+		}
+		for ix, value := range c.take(s, len(verbs), name, word.Pos) {
+			c.expect(value, verb_types(verbs[ix]), fmt.Sprintf("%v %%%c", name, verbs[ix]), word.Pos)
+		}
+		if name == "format" {
+			s.push(typed(TL_STRING.types()))
+		}
+	default:
+		s.lose()
+	}
+}
+
+// call follows a function memory, or the name of one
+func (c *checker) call(function checkValue, s *checkState, pos Position) {
+	c.expect(function, TL_MEMORY.types()|TL_SYMBOL.types(), "call", pos)
+	name := "call"
+	if function.known && function.token.Type == P_SYMBOL {
+		name = function.token.Value.(string) + " call"
+		function = c.vars[function.token.Value.(string)]
+	}
+	if !function.known || function.token.Type != P_MEMORY {
+		s.lose()
+		return
+	}
+	memory := function.token.Value.(IMemory)
+	if effect := c.function_effect(memory); effect != nil {
+		c.apply(s, *effect, false, name, pos)
+	} else if code, ok := memory.Get("code"); ok && code.Type == P_BLOCK && !has_params(memory) {
+		c.run_block(code.Value.(IBlock), s)
+	} else {
+		s.lose()
+	}
+}
+
+func has_params(memory IMemory) bool {
+	params, ok := memory.Get("params")
+	return ok && params.Type == P_STACK
+}
+
+// item_names lists the items of a stack literal
+func item_names(stack IList) []string {
+	var result []string
+	stack.Each(func(_ int, item PToken) {
+		result = append(result, item.Display())
+	})
+	return result
+}
+
+// function_effect checks a function memory with a params key once and
+// returns its effect, nil for other memories or when it is unknown
+func (c *checker) function_effect(memory IMemory) *stackEffect {
+	code, ok := memory.Get("code")
+	if !ok || code.Type != P_BLOCK || !has_params(memory) {
+		return nil
+	}
+	block := code.Value.(IBlock)
+	function, ok := c.functions[block.Pos]
+	if ok {
+		return function.effect
+	}
+	function = &checkFunction{}
+	c.functions[block.Pos] = function
+	params, _ := memory.Get("params")
+	effect := &stackEffect{in: item_names(params.Value.(IList))}
+	returns, has_returns := memory.Get("returns")
+	if has_returns && returns.Type == P_STACK {
+		effect.out = item_names(returns.Value.(IList))
+		function.effect = effect
+	}
+
+	s := &checkState{floor: len(effect.in), inputs: declared_values(effect.in)}
+	c.run_block(block, s)
+	if s.lost || s.ended {
+		return function.effect
+	}
+	leaves := s.depth() + len(effect.in)
+	if function.effect != nil && leaves != len(effect.out) {
+		c.report(block.Pos, "[EFFECT] the function leaves %v, its returns declare %v", count(leaves, "item"), len(effect.out))
+	} else if function.effect != nil {
+		c.expect_results(s, effect.out, "the function", "its returns declare", block.Pos)
+	} else {
+		effect.out = result_names(s, leaves)
+		function.effect = effect
+	}
+	return function.effect
+}
+
+// declared_values are the inputs of a declared effect
+func declared_values(names []string) []checkValue {
+	values := make([]checkValue, len(names))
+	for ix, name := range names {
+		if types, ok := parse_types(name); ok {
+			values[ix] = typed(types)
+		}
+	}
+	return values
+}
+
+// result_names are the types of the top n values, as outputs of an
+// inferred effect
+func result_names(s *checkState, n int) []string {
+	names := make([]string, n)
+	for ix := range names {
+		names[ix] = s.from_top(n - ix).type_set().String()
+	}
+	return names
+}
+
+// expect_results checks the types left at the end of a word against its
+// declared outputs
+func (c *checker) expect_results(s *checkState, outputs []string, name string, declares string, pos Position) {
+	for ix, output := range outputs {
+		expected, ok := parse_types(output)
+		got := s.from_top(len(outputs) - ix).type_set()
+		if ok && c.options.Types && got&expected == 0 {
+			c.report(pos, "[TYPE] %v leaves %v where %v %v", name, got.describe(), declares, expected.describe())
+		}
+	}
+}
+
+// word_effect checks a user word once and returns its declared or
+// inferred effect, nil when it is unknown
+func (c *checker) word_effect(word *checkWord) *stackEffect {
+	if word.done || word.checking {
+		if word.declared != nil {
+			return word.declared
+		}
+		return word.effect
+	}
+	word.checking = true
+	s := &checkState{floor: -1}
+	if word.declared != nil {
+		s.floor, s.inputs = len(word.declared.in), declared_values(word.declared.in)
+	}
+	c.run_words(word.body, s)
+	word.checking, word.done = false, true
+	if word.declared != nil {
+		leaves := s.depth() + len(word.declared.in)
+		if s.lost || s.ended {
+			return word.declared
+		}
+		if leaves != len(word.declared.out) {
+			c.report(word.pos, "[EFFECT] %v leaves %v, its stack effect %v declares %v", word.name, count(leaves, "item"), word.declared, len(word.declared.out))
+		} else {
+			c.expect_results(s, word.declared.out, word.name, fmt.Sprintf("its stack effect %v declares", word.declared), word.pos)
+		}
+		return word.declared
+	}
+	if !s.lost && !s.ended {
+		effect := &stackEffect{in: make([]string, s.taken), out: result_names(s, len(s.items))}
+		for ix := range effect.in {
+			effect.in[ix] = "?"
+		}
+		word.effect = effect
+	}
+	return word.effect
+}
+
+// literal remembers the blocks in a pushed literal and checks the function
+// memories in it
+func (c *checker) literal(token PToken) {
+	if token.Type == P_BLOCK {
+		c.pending = append(c.pending, token.Value.(IBlock))
+	} else if token.Type == P_STACK {
+		token.Value.(IList).Each(func(_ int, item PToken) {
+			c.literal(item)
+		})
+	} else if token.Type == P_MEMORY {
+		memory := token.Value.(IMemory)
+		c.function_effect(memory)
+		memory.Each(func(_ string, value PToken) {
+			c.literal(value)
+		})
+	}
+}
+
+// store binds a variable, a name stored more than once has the types of
+// all its values
+func (c *checker) store(name string, value checkValue) {
+	if old, ok := c.vars[name]; ok && !old.same(value) {
+		value = typed(old.type_set() | value.type_set())
+	}
+	c.vars[name] = value
+}
+
+// block is value as a block, it reports values that cannot be one
+func (c *checker) block(value checkValue, name string, pos Position) (IBlock, bool) {
+	c.expect(value, TL_BLOCK.types(), name, pos)
+	return known_block(value)
+}
+
+func has_var(vars map[string]checkValue, name string) bool {
+	_, ok := vars[name]
+	return ok
+}
+
+func known_block(value checkValue) (IBlock, bool) {
+	if !value.known || value.token.Type != P_BLOCK {
+		return IBlock{}, false
+	}
+	return value.token.Value.(IBlock), true
+}
+
+// count writes n with noun, in plural when needed
+func count(n int, noun string) string {
+	if n == 1 || n == -1 {
+		return fmt.Sprintf("%v %v", n, noun)
+	}
+	return fmt.Sprintf("%v %vs", n, noun)
+}
+
+// difference describes how many more or fewer items a path leaves
+func difference(n int) string {
+	if n < 0 {
+		return count(-n, "fewer item")
+	}
+	return count(n, "more item")
+}
+
+// run_check checks the scripts in paths and prints what it finds, it
+// returns the exit code
+func run_check(paths []string, options CheckOptions) int {
+	files, err := find_files(paths, ".nm")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	status := 0
+	for _, path := range files {
+		diagnostics, err := CheckFile(path, options)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		for _, diagnostic := range diagnostics {
+			fmt.Println(diagnostic)
+			status = 1
+		}
+	}
+	return status
+}
@@ -0,0 +1,68 @@
+package numen
+
+import (
+	"strings"
+	"testing"
+)
+
+func check_messages(t *testing.T, code string, options CheckOptions) []string {
+	t.Helper()
+	diagnostics, err := check_code(code, Position{"check.nm", 1, 1}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, diagnostic := range diagnostics {
+		messages = append(messages, strings.TrimPrefix(diagnostic.String(), "check.nm:"))
+	}
+	return messages
+}
+
+type checkCase struct {
+	name string
+	code string
+	want []string
+}
+
+func run_check_cases(t *testing.T, cases []checkCase, options CheckOptions) {
+	t.Helper()
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			got := check_messages(t, test.code, options)
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("%v\ngot  %q\nwant %q", test.code, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	run_check_cases(t, []checkCase{
+		{"balanced", "1 2 + println", nil},
+		{"underflow", "1 +", []string{"1:3: [UNDERFLOW] + takes 2 items, the stack holds 1"}},
+		{"underflow inside a block", "true { drop } if", []string{"1:8: [UNDERFLOW] drop takes 1 item, the stack holds 0"}},
+
+		{"if that keeps the depth", "1 true { 1 + } if println", nil},
+		{"if that pushes", "true { 1 } if", []string{"1:12: [IF] the block leaves 1 more item than skipping it"}},
+		{"arms that leave different depths", "1 true { drop } if false { 1 2 } if", []string{
+			"1:17: [IF] the block leaves 1 fewer item than skipping it",
+			"1:34: [IF] the block leaves 2 more items than skipping it",
+		}},
+
+		{"loop with a balanced body", "{ 1 drop break } loop", nil},
+		{"loop that grows the stack", "{ 1 } loop", []string{"1:7: [LOOP] every iteration leaves 1 more item on the stack"}},
+		{"breaks that leave different depths", "{ true { 1 break } if break } loop", []string{"1:23: [BREAK] leaves 1 fewer item than the break at 1:12"}},
+
+		{"annotated word", ": sq ( a -- b ) dup * ; 2 sq println", nil},
+		{"annotated word that disagrees", ": sq ( a -- b ) dup ; 2 sq", []string{"1:3: [EFFECT] sq leaves 2 items, its stack effect ( a -- b ) declares 1"}},
+		{"empty effect", ": noop ( -- ) ; noop", nil},
+		{"empty effect that leaves a value", ": one ( -- ) 1 ; one", []string{"1:3: [EFFECT] one leaves 1 item, its stack effect ( -- ) declares 0"}},
+		{"inferred word", ": two 1 2 ; two + println", nil},
+		{"inferred word underflow", ": sq dup * ; sq", []string{"1:14: [UNDERFLOW] sq takes 1 item, the stack holds 0"}},
+
+		{"printf with its values", `1 2 "%v %v\n" printf`, nil},
+		{"printf missing a value", `1 "%v %v\n" printf`, []string{"1:13: [UNDERFLOW] printf takes 2 items, the stack holds 1"}},
+		{"format counts its verbs", `1 2 "%v" format`, nil},
+		{"unknown verb", `"%q" printf`, []string{"1:6: [FORMAT] unknown verb %q"}},
+	}, CheckOptions{})
+}
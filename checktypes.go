@@ -0,0 +1,156 @@
+package numen
+
+import (
+	"strings"
+)
+
+// numen check -types follows the type of every value as a set of the
+// types it may have. A word is only reported when a value cannot have any
+// of the types the word accepts, so values of unknown type never are.
+
+// typeSet has a bit for every type literal but any, zero stands for any
+type typeSet uint16
+
+const (
+	anyType    typeSet = 1<<TL_ANY - 1
+	numberType typeSet = 1<<TL_INT | 1<<TL_FLOAT
+)
+
+// parse_types reads a type name such as int, num or str|symbol, it is
+// false for names that are not types
+func parse_types(name string) (typeSet, bool) {
+	var types typeSet
+	for _, part := range strings.Split(name, "|") {
+		if part == "num" {
+			types |= numberType
+			continue
+		}
+		found := false
+		for literal, literal_name := range TypeLiteralStr {
+			if part == literal_name {
+				types |= literal.types()
+				found = true
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return types, true
+}
+
+func (tl TypeLiterals) types() typeSet {
+	if tl == TL_ANY {
+		return anyType
+	}
+	return 1 << tl
+}
+
+// token_types is the type of a literal
+func token_types(token PToken) typeSet {
+	switch token.Type {
+	case P_INT:
+		return TL_INT.types()
+	case P_FLOAT:
+		return TL_FLOAT.types()
+	case P_STRING:
+		return TL_STRING.types()
+	case P_BOOLEAN:
+		return TL_BOOLEAN.types()
+	case P_BLOCK:
+		return TL_BLOCK.types()
+	case P_STACK:
+		return TL_STACK.types()
+	case P_MEMORY:
+		return TL_MEMORY.types()
+	case P_SYMBOL:
+		return TL_SYMBOL.types()
+	}
+	return anyType
+}
+
+func (types typeSet) names() []string {
+	if types == 0 || types == anyType {
+		return []string{"any"}
+	}
+	var names []string
+	for literal := TL_INT; literal < TL_ANY; literal++ {
+		if types&literal.types() != 0 {
+			names = append(names, literal.String())
+		}
+	}
+	return names
+}
+
+func (types typeSet) String() string {
+	return strings.Join(types.names(), "|")
+}
+
+// describe writes the types for messages, as int or float
+func (types typeSet) describe() string {
+	return strings.Join(types.names(), " or ")
+}
+
+func (v checkValue) type_set() typeSet {
+	if v.types == 0 {
+		return anyType
+	}
+	return v.types
+}
+
+// typed is an unknown value of the given types
+func typed(types typeSet) checkValue {
+	return checkValue{types: types}
+}
+
+// literal_value is a pushed literal
+func literal_value(token PToken) checkValue {
+	return checkValue{token: token, known: true, types: token_types(token)}
+}
+
+// expect reports a value that cannot have any of the expected types
+func (c *checker) expect(value checkValue, expected typeSet, name string, pos Position) {
+	if c.options.Types && value.type_set()&expected == 0 {
+		c.report(pos, "[TYPE] %v expects %v, got %v", name, expected.describe(), value.type_set().describe())
+	}
+}
+
+// arithmetic checks the operands of + - * / and returns the result type,
+// + also joins strings
+func (c *checker) arithmetic(name string, first checkValue, second checkValue, pos Position) typeSet {
+	a, b := first.type_set(), second.type_set()
+	var result typeSet
+	if a&numberType != 0 && b&numberType != 0 {
+		if a&TL_INT.types() != 0 && b&TL_INT.types() != 0 {
+			result |= TL_INT.types()
+		}
+		if a&TL_FLOAT.types() != 0 || b&TL_FLOAT.types() != 0 {
+			result |= TL_FLOAT.types()
+		}
+	}
+	if name == "+" && a&TL_STRING.types() != 0 && b&TL_STRING.types() != 0 {
+		result |= TL_STRING.types()
+	}
+	if result == 0 {
+		if c.options.Types {
+			expected := "two numbers"
+			if name == "+" {
+				expected = "two numbers or two strings"
+			}
+			c.report(pos, "[TYPE] %v expects %v, got %v and %v", name, expected, a.describe(), b.describe())
+		}
+		return anyType
+	}
+	return result
+}
+
+// verb_types is what a printf verb accepts
+func verb_types(verb rune) typeSet {
+	switch verb {
+	case 'd':
+		return TL_INT.types()
+	case 'f':
+		return numberType
+	}
+	return anyType
+}
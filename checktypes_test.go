@@ -0,0 +1,26 @@
+package numen
+
+import "testing"
+
+func TestCheckTypes(t *testing.T) {
+	run_check_cases(t, []checkCase{
+		{"matching types", `1 2.5 + "a" "b" + println println`, nil},
+		{"mismatch into a builtin", `"a" 1 +`, []string{"1:7: [TYPE] + expects two numbers or two strings, got str and int"}},
+		{"mismatch after inference", `1 2.5 + "a" +`, []string{"1:13: [TYPE] + expects two numbers or two strings, got float and str"}},
+		{"condition that is no bool", `1 { } if`, []string{"1:7: [TYPE] if expects bool, got int"}},
+		{"printf verb", `"a" "%d" printf`, []string{"1:10: [TYPE] printf %d expects int, got str"}},
+
+		// a value an if may replace has either type afterwards
+		{"union from if arms", `1 true { drop "a" } if { } if`, []string{"1:28: [TYPE] if expects bool, got int or str"}},
+		{"union through a variable", `1 true { drop "a" } if 'x store x { } if`, []string{"1:39: [TYPE] if expects bool, got int or str"}},
+		{"union that fits", `1 true { drop "a" } if 2 +`, nil},
+		{"union input", `: show ( int|str -- ) drop ; "a" show 1 show true show`, []string{"1:51: [TYPE] show expects int or str, got bool"}},
+
+		{"annotation that agrees", `: inc ( int -- int ) 1 + ; 2 inc`, nil},
+		{"annotated output disagrees", `: name ( int -- str ) 1 + ; 2 name`, []string{"1:3: [TYPE] name leaves int where its stack effect ( int -- str ) declares str"}},
+		{"annotated output disagrees with an if", `: flag ( -- bool ) 1 true { drop "a" } if ; flag`, []string{"1:3: [TYPE] flag leaves int or str where its stack effect ( -- bool ) declares bool"}},
+		{"annotated input disagrees with the body", `: inc ( int -- int ) "a" + ; 1 inc`, []string{"1:26: [TYPE] + expects two numbers or two strings, got int and str"}},
+		{"caller disagrees with the annotation", `: inc ( int -- int ) ; "a" inc`, []string{"1:28: [TYPE] inc expects int, got str"}},
+		{"function returns", `[ params ( int ) returns ( str ) code { 1 + } ] 'g store 1 'g call`, []string{"1:41: [TYPE] the function leaves int where its returns declare str"}},
+	}, CheckOptions{Types: true})
+}
@@ -0,0 +1,8 @@
+// Command numen runs Numen scripts, see numen -h
+package main
+
+import "dorukyilmaz.net/numen"
+
+func main() {
+	numen.Main()
+}
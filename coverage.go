@@ -0,0 +1,479 @@
+package numen
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Coverage counts the words, blocks and branches a run reached. The
+// report side parses the source files again to find what never ran.
+type Coverage struct {
+	words    map[Position]int64
+	blocks   map[Position]int64   // by where the block's code starts
+	branches map[Position][]int64 // if: taken and skipped, loop: iterations
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{
+		words:    map[Position]int64{},
+		blocks:   map[Position]int64{},
+		branches: map[Position][]int64{},
+	}
+}
+
+func WithCoverage(coverage *Coverage) Option {
+	return func(in *Interpreter) {
+		in.Coverage = coverage
+	}
+}
+
+func (c *Coverage) hit(pos Position) {
+	c.words[pos]++
+}
+
+func (c *Coverage) enter(pos Position) {
+	c.blocks[pos]++
+}
+
+// branch counts arm of the if or loop at pos
+func (c *Coverage) branch(pos Position, arms int, arm int) {
+	counts, ok := c.branches[pos]
+	if !ok {
+		counts = make([]int64, arms)
+		c.branches[pos] = counts
+	}
+	counts[arm]++
+}
+
+// files lists the files that ran, and those in paths even if they did not
+func (c *Coverage) files(paths []string) []string {
+	seen := map[string]bool{}
+	var files []string
+	add := func(file string) {
+		if file != "" && !strings.HasPrefix(file, "<") && !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	for _, path := range paths {
+		add(path)
+	}
+	var ran []string
+	for pos := range c.words {
+		ran = append(ran, pos.File)
+	}
+	sort.Strings(ran)
+	for _, file := range ran {
+		add(file)
+	}
+	return files
+}
+
+type wordCoverage struct {
+	pos    Position
+	length int // runes of the word in the source, 1 for literals with a body
+	count  int64
+}
+
+type branchCoverage struct {
+	pos    Position
+	word   string  // if or loop
+	counts []int64 // nil when the branch was never reached
+}
+
+type functionCoverage struct {
+	name  string
+	line  int
+	count int64
+}
+
+// fileCoverage is the coverage of one source file
+type fileCoverage struct {
+	path      string
+	lines     []string
+	words     []wordCoverage
+	blocks    int
+	ran       int // blocks that ran
+	branches  []branchCoverage
+	functions []functionCoverage
+}
+
+// file parses path and matches every word in it with the counts
+func (c *Coverage) file(path string) (*fileCoverage, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &fileCoverage{path: path, lines: strings.Split(string(source), "\n")}
+	var walk_code func(code string, start Position) *NumenError
+	var walk_literal func(token PToken)
+	walk_literal = func(token PToken) {
+		if token.Type == P_BLOCK {
+			block := token.Value.(IBlock)
+			result.blocks++
+			if c.blocks[block.Pos] > 0 {
+				result.ran++
+			}
+			walk_code(block.Code, block.Pos)
+		} else if token.Type == P_STACK {
+			token.Value.(IList).Each(func(_ int, item PToken) {
+				walk_literal(item)
+			})
+		} else if token.Type == P_MEMORY {
+			token.Value.(IMemory).Each(func(_ string, value PToken) {
+				walk_literal(value)
+			})
+		}
+	}
+	walk_code = func(code string, start Position) *NumenError {
+		interp_chan, wait := parse_async(code, start)
+		defining := 0 // 2 right after :, 1 inside the body
+		for word := range interp_chan {
+			is_word := word.Type == P_SYMBOL && !word.Quoted
+			if defining == 2 {
+				// the name is read by :, it never runs itself
+				defining = 1
+				body, ok := <-interp_chan
+				if _, annotated := effect_annotation(body.PToken); ok && annotated {
+					// the stack effect comment is not part of the body
+					body, ok = <-interp_chan
+				}
+				if ok {
+					result.functions = append(result.functions, functionCoverage{word.Value.(string), word.Pos.Line, c.blocks[body.Pos]})
+					word = body
+					is_word = word.Type == P_SYMBOL && !word.Quoted
+				} else {
+					break
+				}
+			}
+			if defining == 1 && is_word && word.Value == ";" {
+				defining = 0
+				continue
+			}
+			if is_word && word.Value == ":" {
+				defining = 2
+			}
+
+			length := 1
+			if word.Type != P_BLOCK && word.Type != P_STACK && word.Type != P_MEMORY {
+				length = utf8.RuneCountInString(word.Repr())
+			}
+			result.words = append(result.words, wordCoverage{word.Pos, length, c.words[word.Pos]})
+			if is_word && (word.Value == "if" || word.Value == "loop") {
+				result.branches = append(result.branches, branchCoverage{word.Pos, word.Value.(string), c.branches[word.Pos]})
+			}
+			walk_literal(word.PToken)
+		}
+		return wait()
+	}
+	if err := walk_code(string(source), Position{path, 1, 1}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(result.words, func(a, b int) bool {
+		return before(result.words[a].pos, result.words[b].pos)
+	})
+	sort.SliceStable(result.branches, func(a, b int) bool {
+		return before(result.branches[a].pos, result.branches[b].pos)
+	})
+	return result, nil
+}
+
+func before(a Position, b Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+}
+
+// line_counts is the highest word count of every line with words, and
+// whether a word on the line never ran
+func (f *fileCoverage) line_counts() (counts map[int]int64, partial map[int]bool) {
+	counts, partial = map[int]int64{}, map[int]bool{}
+	for _, word := range f.words {
+		counts[word.pos.Line] = max(counts[word.pos.Line], word.count)
+		if word.count == 0 {
+			partial[word.pos.Line] = true
+		}
+	}
+	return counts, partial
+}
+
+func (f *fileCoverage) summary() string {
+	ran := 0
+	for _, word := range f.words {
+		if word.count > 0 {
+			ran++
+		}
+	}
+	arms, taken := 0, 0
+	for _, branch := range f.branches {
+		for _, count := range branch.arms() {
+			arms++
+			if count > 0 {
+				taken++
+			}
+		}
+	}
+	return fmt.Sprintf("%v: words %v/%v (%.1f%%), blocks %v/%v, branches %v/%v",
+		f.path, ran, len(f.words), percent_of(ran, len(f.words)), f.ran, f.blocks, taken, arms)
+}
+
+// arms returns a count per arm, zeros when the branch was never reached
+func (b branchCoverage) arms() []int64 {
+	if b.counts != nil {
+		return b.counts
+	}
+	if b.word == "if" {
+		return []int64{0, 0}
+	}
+	return []int64{0}
+}
+
+func (b branchCoverage) describe() string {
+	if b.counts == nil {
+		return fmt.Sprintf("%v at %v never reached", b.word, b.pos.Col)
+	}
+	if b.word == "if" {
+		return fmt.Sprintf("if at %v taken %v, skipped %v", b.pos.Col, b.counts[0], b.counts[1])
+	}
+	return fmt.Sprintf("loop at %v ran its body %v times", b.pos.Col, b.counts[0])
+}
+
+func percent_of(part int, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+// WriteCoverageText writes the summary and every line with its hit count,
+// lines marked with ! have words that never ran
+func WriteCoverageText(out io.Writer, files []*fileCoverage) {
+	for _, file := range files {
+		fmt.Fprintln(out, file.summary())
+		counts, partial := file.line_counts()
+		branches := map[int][]string{}
+		for _, branch := range file.branches {
+			branches[branch.pos.Line] = append(branches[branch.pos.Line], branch.describe())
+		}
+		for ix, line := range file.lines {
+			number := ix + 1
+			count, ok := counts[number]
+			column := ""
+			if ok {
+				column = fmt.Sprint(count)
+				if partial[number] {
+					column += "!"
+				}
+			}
+			note := ""
+			if len(branches[number]) > 0 {
+				note = "    // " + strings.Join(branches[number], "; ")
+			}
+			if ix == len(file.lines)-1 && line == "" {
+				break
+			}
+			fmt.Fprintf(out, "%9v | %v%v\n", column, line, note)
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// WriteCoverageHTML writes the sources with every word colored by whether
+// it ran, the title of a word shows its count
+func WriteCoverageHTML(out io.Writer, files []*fileCoverage) {
+	fmt.Fprint(out, `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Numen coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; line-height: 1.3; }
+.count { display: inline-block; width: 6em; text-align: right; color: #888; }
+.hit { background: #c8f0c8; }
+.miss { background: #f4c0c0; }
+.branch { color: #a05000; }
+</style></head><body>
+`)
+	for _, file := range files {
+		fmt.Fprintf(out, "<h2>%v</h2>\n<pre>\n", html.EscapeString(file.summary()))
+		counts, _ := file.line_counts()
+		words_by_line := map[int][]wordCoverage{}
+		for _, word := range file.words {
+			words_by_line[word.pos.Line] = append(words_by_line[word.pos.Line], word)
+		}
+		branches := map[int][]string{}
+		for _, branch := range file.branches {
+			branches[branch.pos.Line] = append(branches[branch.pos.Line], branch.describe())
+		}
+		for ix, line := range file.lines {
+			number := ix + 1
+			if ix == len(file.lines)-1 && line == "" {
+				break
+			}
+			count := ""
+			if value, ok := counts[number]; ok {
+				count = fmt.Sprint(value)
+			}
+			fmt.Fprintf(out, "<span class=\"count\">%v</span> %v", count, highlight_line([]rune(line), words_by_line[number]))
+			if len(branches[number]) > 0 {
+				fmt.Fprintf(out, "  <span class=\"branch\">// %v</span>", html.EscapeString(strings.Join(branches[number], "; ")))
+			}
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintln(out, "</pre>")
+	}
+	fmt.Fprintln(out, "</body></html>")
+}
+
+func highlight_line(line []rune, words []wordCoverage) string {
+	var builder strings.Builder
+	col := 0
+	for _, word := range words {
+		start := word.pos.Col - 1
+		if start < col || start >= len(line) {
+			continue
+		}
+		end := min(start+word.length, len(line))
+		builder.WriteString(html.EscapeString(string(line[col:start])))
+		class := "hit"
+		if word.count == 0 {
+			class = "miss"
+		}
+		fmt.Fprintf(&builder, "<span class=\"%v\" title=\"%v\">%v</span>", class, word.count, html.EscapeString(string(line[start:end])))
+		col = end
+	}
+	builder.WriteString(html.EscapeString(string(line[col:])))
+	return builder.String()
+}
+
+// WriteCoverageLCOV writes an LCOV tracefile, user words are its functions
+// and every if and loop is a branch block
+func WriteCoverageLCOV(out io.Writer, files []*fileCoverage) {
+	for _, file := range files {
+		path := file.path
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		fmt.Fprintf(out, "TN:\nSF:%v\n", path)
+		functions_hit := 0
+		for _, function := range file.functions {
+			fmt.Fprintf(out, "FN:%v,%v\n", function.line, function.name)
+		}
+		for _, function := range file.functions {
+			fmt.Fprintf(out, "FNDA:%v,%v\n", function.count, function.name)
+			if function.count > 0 {
+				functions_hit++
+			}
+		}
+		fmt.Fprintf(out, "FNF:%v\nFNH:%v\n", len(file.functions), functions_hit)
+		arms, taken := 0, 0
+		for block, branch := range file.branches {
+			for arm, count := range branch.arms() {
+				arms++
+				if branch.counts == nil {
+					fmt.Fprintf(out, "BRDA:%v,%v,%v,-\n", branch.pos.Line, block, arm)
+					continue
+				}
+				if count > 0 {
+					taken++
+				}
+				fmt.Fprintf(out, "BRDA:%v,%v,%v,%v\n", branch.pos.Line, block, arm, count)
+			}
+		}
+		fmt.Fprintf(out, "BRF:%v\nBRH:%v\n", arms, taken)
+		counts, _ := file.line_counts()
+		lines := make([]int, 0, len(counts))
+		for line := range counts {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		lines_hit := 0
+		for _, line := range lines {
+			fmt.Fprintf(out, "DA:%v,%v\n", line, counts[line])
+			if counts[line] > 0 {
+				lines_hit++
+			}
+		}
+		fmt.Fprintf(out, "LF:%v\nLH:%v\nend_of_record\n", len(lines), lines_hit)
+	}
+}
+
+// run_cover runs every script with a fresh stack and scope, reports the
+// coverage of all of them and returns the exit code. Directories and
+// *_test.nm files run as test suites.
+func run_cover(paths []string, html_path string, lcov_path string) int {
+	coverage := NewCoverage()
+	WithCoverage(coverage)(interp)
+	exit_code := 0
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err == nil && info.IsDir() || strings.HasSuffix(path, "_test.nm") {
+			tests, err := find_test_files([]string{path})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit_code = 1
+				continue
+			}
+			files = append(files, tests...)
+			if !run_tests(tests, TestOptions{Out: os.Stderr}) {
+				exit_code = 1
+			}
+			continue
+		}
+		files = append(files, path)
+		reset_globals()
+		interp.loading = nil
+		if abs, err := filepath.Abs(path); err == nil {
+			interp.loading = append(interp.loading, abs)
+		}
+		if err := protect(func() {
+			code, err := os.ReadFile(path)
+			if err != nil {
+				panicf("%v", err)
+			}
+			run_function(string(code), Position{path, 1, 1}, globalScope)
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit_code = 1
+		}
+	}
+	write_coverage(coverage, files, html_path, lcov_path)
+	return exit_code
+}
+
+// write_coverage reports on every file that ran and on paths, the text
+// report goes to stderr
+func write_coverage(coverage *Coverage, paths []string, html_path string, lcov_path string) {
+	var files []*fileCoverage
+	for _, path := range coverage.files(paths) {
+		file, err := coverage.file(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cover: %v\n", err)
+			continue
+		}
+		files = append(files, file)
+	}
+	WriteCoverageText(os.Stderr, files)
+	write_report(html_path, func(out io.Writer) { WriteCoverageHTML(out, files) })
+	write_report(lcov_path, func(out io.Writer) { WriteCoverageLCOV(out, files) })
+}
+
+// write_report creates path and writes it with write, an empty path is
+// skipped
+func write_report(path string, write func(out io.Writer)) {
+	if path == "" {
+		return
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	write(file)
+	if err := file.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
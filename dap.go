@@ -0,0 +1,473 @@
+package numen
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// numen dap speaks the Debug Adapter Protocol over stdin and stdout. A
+// goroutine reads requests, the script and every request that looks at it
+// run on the main goroutine: while stopped in Stopped, while running in
+// Poll.
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type dapSource struct {
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+}
+
+// the only thread a Numen script has
+const dap_thread = 1
+
+type DAPServer struct {
+	out      io.Writer
+	out_lock sync.Mutex
+	seq      int
+
+	requests chan dapRequest // closed when the client goes away
+
+	program       string
+	stop_on_entry bool
+	entered       bool                   // the first stop was reported
+	disconnected  bool                   // the client sent disconnect
+	references    []func() []dapVariable // variable trees of the current stop
+}
+
+func NewDAPServer(in io.Reader, out io.Writer) *DAPServer {
+	server := &DAPServer{out: out, requests: make(chan dapRequest, 16)}
+	go server.read(bufio.NewReader(in))
+	return server
+}
+
+// read forwards every request from the client to the requests channel
+func (s *DAPServer) read(in *bufio.Reader) {
+	defer close(s.requests)
+	for {
+		length := -1
+		for {
+			header, err := in.ReadString('\n')
+			if err != nil {
+				return
+			}
+			header = strings.TrimSpace(header)
+			if header == "" {
+				break
+			}
+			if name, value, ok := strings.Cut(header, ":"); ok && strings.EqualFold(name, "Content-Length") {
+				length, _ = strconv.Atoi(strings.TrimSpace(value))
+			}
+		}
+		if length < 0 {
+			continue
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(in, data); err != nil {
+			return
+		}
+		var request dapRequest
+		if json.Unmarshal(data, &request) == nil && request.Type == "request" {
+			s.requests <- request
+		}
+	}
+}
+
+func (s *DAPServer) send(message any) {
+	s.out_lock.Lock()
+	defer s.out_lock.Unlock()
+	s.seq++
+	switch message := message.(type) {
+	case *dapResponse:
+		message.Seq = s.seq
+	case *dapEvent:
+		message.Seq = s.seq
+	}
+	data, _ := json.Marshal(message)
+	fmt.Fprintf(s.out, "Content-Length: %v\r\n\r\n%s", len(data), data)
+}
+
+func (s *DAPServer) respond(request dapRequest, body any) {
+	s.send(&dapResponse{Type: "response", RequestSeq: request.Seq, Success: true, Command: request.Command, Body: body})
+}
+
+func (s *DAPServer) fail(request dapRequest, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	s.send(&dapResponse{Type: "response", RequestSeq: request.Seq, Command: request.Command, Message: message})
+}
+
+func (s *DAPServer) event(name string, body any) {
+	s.send(&dapEvent{Type: "event", Event: name, Body: body})
+}
+
+// Write makes the server the script's Stdout, output goes to the client
+func (s *DAPServer) Write(data []byte) (int, error) {
+	s.event("output", map[string]any{"category": "stdout", "output": string(data)})
+	return len(data), nil
+}
+
+// Serve configures the session, runs the launched program and waits for
+// the client to disconnect. It returns the exit code for the process.
+func (s *DAPServer) Serve(dbg *Debugger) int {
+	launched, configured := false, false
+	for !launched || !configured {
+		request, ok := <-s.requests
+		if !ok {
+			return 0
+		}
+		switch request.Command {
+		case "initialize":
+			s.respond(request, map[string]any{
+				"supportsConfigurationDoneRequest": true,
+				"supportsConditionalBreakpoints":   true,
+				"supportsEvaluateForHovers":        true,
+				"supportsTerminateRequest":         true,
+			})
+			s.event("initialized", nil)
+		case "launch":
+			var arguments struct {
+				Program     string `json:"program"`
+				StopOnEntry bool   `json:"stopOnEntry"`
+			}
+			json.Unmarshal(request.Arguments, &arguments)
+			if arguments.Program == "" {
+				s.fail(request, "launch needs a program")
+				continue
+			}
+			s.program, s.stop_on_entry = arguments.Program, arguments.StopOnEntry
+			launched = true
+			s.respond(request, nil)
+		case "configurationDone":
+			configured = true
+			s.respond(request, nil)
+		case "disconnect", "terminate":
+			s.respond(request, nil)
+			return 0
+		default:
+			s.handle(dbg, request)
+		}
+	}
+
+	exit_code := s.run(dbg)
+	s.event("exited", map[string]any{"exitCode": exit_code})
+	s.event("terminated", nil)
+	if s.disconnected {
+		return exit_code
+	}
+	for request := range s.requests {
+		if request.Command == "disconnect" {
+			s.respond(request, nil)
+			break
+		}
+		s.fail(request, "the program has ended")
+	}
+	return exit_code
+}
+
+// run runs the launched program, errors are sent as output
+func (s *DAPServer) run(dbg *Debugger) int {
+	code_raw, err := os.ReadFile(s.program)
+	if err != nil {
+		s.event("output", map[string]any{"category": "stderr", "output": err.Error() + "\n"})
+		return 1
+	}
+	if abs, err := filepath.Abs(s.program); err == nil {
+		interp.loading = append(interp.loading, abs)
+	}
+	if !s.stop_on_entry {
+		dbg.Continue()
+	}
+	if err := protect(func() {
+		run_function(string(code_raw), Position{s.program, 1, 1}, globalScope)
+	}); err != nil {
+		s.event("output", map[string]any{"category": "stderr", "output": err.Error() + "\n"})
+		return 1
+	}
+	return 0
+}
+
+// handle answers the requests that need no stopped script
+func (s *DAPServer) handle(dbg *Debugger, request dapRequest) {
+	switch request.Command {
+	case "threads":
+		s.respond(request, map[string]any{"threads": []map[string]any{{"id": dap_thread, "name": "main"}}})
+	case "setBreakpoints":
+		var arguments struct {
+			Source      dapSource `json:"source"`
+			Breakpoints []struct {
+				Line      int    `json:"line"`
+				Condition string `json:"condition"`
+			} `json:"breakpoints"`
+		}
+		json.Unmarshal(request.Arguments, &arguments)
+		dbg.ClearBreakpoints(arguments.Source.Path)
+		result := []map[string]any{}
+		for _, wanted := range arguments.Breakpoints {
+			breakpoint := dbg.AddBreakpoint(arguments.Source.Path, wanted.Line, wanted.Condition)
+			result = append(result, map[string]any{"id": breakpoint.ID, "verified": true, "line": wanted.Line})
+		}
+		s.respond(request, map[string]any{"breakpoints": result})
+	case "setExceptionBreakpoints":
+		s.respond(request, map[string]any{"breakpoints": []any{}})
+	default:
+		s.fail(request, "%v is not supported while the program runs", request.Command)
+	}
+}
+
+// Poll takes the requests that arrived while the script runs
+func (s *DAPServer) Poll(dbg *Debugger) {
+	for {
+		select {
+		case request, ok := <-s.requests:
+			if !ok {
+				dbg.Quit()
+				return
+			}
+			switch request.Command {
+			case "pause":
+				dbg.Pause()
+				s.respond(request, nil)
+			case "disconnect", "terminate":
+				s.disconnected = request.Command == "disconnect"
+				dbg.Quit()
+				s.respond(request, nil)
+			default:
+				s.handle(dbg, request)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Stopped reports the stop and answers requests until the client resumes
+func (s *DAPServer) Stopped(dbg *Debugger, reason string) {
+	s.references = nil
+	body := map[string]any{"threadId": dap_thread, "allThreadsStopped": true}
+	if !s.entered && s.stop_on_entry {
+		body["reason"] = "entry"
+	} else if id, ok := strings.CutPrefix(reason, "breakpoint "); ok {
+		body["reason"] = "breakpoint"
+		id, _, _ = strings.Cut(id, ",")
+		if number, err := strconv.Atoi(id); err == nil {
+			body["hitBreakpointIds"] = []int{number}
+		}
+		if strings.Contains(reason, "condition failed") {
+			body["description"] = reason
+		}
+	} else if reason == "breakpoint word" {
+		body["reason"] = "breakpoint"
+	} else {
+		body["reason"] = reason
+	}
+	s.entered = true
+	s.event("stopped", body)
+
+	for request := range s.requests {
+		switch request.Command {
+		case "continue":
+			dbg.Continue()
+			s.respond(request, map[string]any{"allThreadsContinued": true})
+			return
+		case "next":
+			dbg.Next()
+			s.respond(request, nil)
+			return
+		case "stepIn":
+			dbg.Step()
+			s.respond(request, nil)
+			return
+		case "stepOut":
+			dbg.Finish()
+			s.respond(request, nil)
+			return
+		case "disconnect", "terminate":
+			s.disconnected = request.Command == "disconnect"
+			dbg.Quit()
+			s.respond(request, nil)
+			return
+		case "pause":
+			s.respond(request, nil)
+		case "stackTrace":
+			s.stack_trace(dbg, request)
+		case "scopes":
+			s.scopes(dbg, request)
+		case "variables":
+			var arguments struct {
+				VariablesReference int `json:"variablesReference"`
+			}
+			json.Unmarshal(request.Arguments, &arguments)
+			if arguments.VariablesReference < 1 || arguments.VariablesReference > len(s.references) {
+				s.fail(request, "unknown variables reference %v", arguments.VariablesReference)
+				continue
+			}
+			variables := s.references[arguments.VariablesReference-1]()
+			if variables == nil {
+				variables = []dapVariable{}
+			}
+			s.respond(request, map[string]any{"variables": variables})
+		case "evaluate":
+			s.evaluate(dbg, request)
+		default:
+			s.handle(dbg, request)
+		}
+	}
+	// the client went away
+	s.disconnected = true
+	dbg.Quit()
+}
+
+// frame finds the frame of a client frame id, ids count from the innermost
+// frame starting at 1
+func (s *DAPServer) frame(dbg *Debugger, frame_id int) *Frame {
+	frames := dbg.Frames()
+	if frame_id < 1 || frame_id > len(frames) {
+		return dbg.top()
+	}
+	return frames[len(frames)-frame_id]
+}
+
+func (s *DAPServer) stack_trace(dbg *Debugger, request dapRequest) {
+	frames := dbg.Frames()
+	var result []map[string]any
+	for ix := len(frames) - 1; ix >= 0; ix-- {
+		frame := frames[ix]
+		path := frame.Pos.File
+		if abs, err := filepath.Abs(path); err == nil && !strings.HasPrefix(path, "<") {
+			path = abs
+		}
+		result = append(result, map[string]any{
+			"id":     len(frames) - ix,
+			"name":   frame.Name,
+			"source": dapSource{Name: filepath.Base(frame.Pos.File), Path: path},
+			"line":   frame.Pos.Line,
+			"column": frame.Pos.Col,
+		})
+	}
+	s.respond(request, map[string]any{"stackFrames": result, "totalFrames": len(result)})
+}
+
+func (s *DAPServer) scopes(dbg *Debugger, request dapRequest) {
+	var arguments struct {
+		FrameID int `json:"frameId"`
+	}
+	json.Unmarshal(request.Arguments, &arguments)
+	frame := s.frame(dbg, arguments.FrameID)
+
+	stack := append(IStack(nil), globalStack...)
+	stack_ref := s.reference(func() (variables []dapVariable) {
+		for ix, token := range stack {
+			variables = append(variables, s.variable(strconv.Itoa(ix), token))
+		}
+		return variables
+	})
+	locals_ref := s.reference(func() (variables []dapVariable) {
+		// every scope between the frame and the global one
+		seen := map[string]bool{}
+		for scope := frame.Scope; scope != nil && scope != globalScope; scope = scope.parent {
+			names, values := Variables(&IScope{vars: scope.vars})
+			for ix, name := range names {
+				if !seen[name] {
+					seen[name] = true
+					variables = append(variables, s.variable(name, values[ix]))
+				}
+			}
+		}
+		return variables
+	})
+	globals_ref := s.reference(func() (variables []dapVariable) {
+		names, values := Variables(globalScope)
+		for ix, name := range names {
+			variables = append(variables, s.variable(name, values[ix]))
+		}
+		return variables
+	})
+	s.respond(request, map[string]any{"scopes": []map[string]any{
+		{"name": "Stack", "variablesReference": stack_ref, "indexedVariables": len(stack), "expensive": false},
+		{"name": "Locals", "variablesReference": locals_ref, "expensive": false},
+		{"name": "Globals", "variablesReference": globals_ref, "expensive": false},
+	}})
+}
+
+func (s *DAPServer) evaluate(dbg *Debugger, request dapRequest) {
+	var arguments struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	json.Unmarshal(request.Arguments, &arguments)
+	result, err := dbg.Evaluate(arguments.Expression, s.frame(dbg, arguments.FrameID).Scope)
+	if err != nil {
+		s.fail(request, "%v", err.Message)
+		return
+	}
+	if len(result) == 0 {
+		s.respond(request, map[string]any{"result": "( )", "variablesReference": 0})
+		return
+	}
+	top := s.variable("", result[len(result)-1])
+	s.respond(request, map[string]any{"result": top.Value, "type": top.Type, "variablesReference": top.VariablesReference})
+}
+
+// reference registers a variable tree for the current stop
+func (s *DAPServer) reference(children func() []dapVariable) int {
+	s.references = append(s.references, children)
+	return len(s.references)
+}
+
+// variable shows a value, stacks and memories can be expanded
+func (s *DAPServer) variable(name string, token PToken) dapVariable {
+	variable := dapVariable{Name: name, Value: display_nested(token), Type: fmt.Sprint(token.Type)}
+	if token.Type == P_STACK {
+		list := token.Value.(IList)
+		variable.VariablesReference = s.reference(func() (variables []dapVariable) {
+			list.Each(func(ix int, item PToken) {
+				variables = append(variables, s.variable(strconv.Itoa(ix), item))
+			})
+			return variables
+		})
+	} else if token.Type == P_MEMORY {
+		memory := token.Value.(IMemory)
+		variable.VariablesReference = s.reference(func() (variables []dapVariable) {
+			memory.Each(func(key string, value PToken) {
+				variables = append(variables, s.variable(key, value))
+			})
+			return variables
+		})
+	}
+	return variable
+}
@@ -0,0 +1,153 @@
+package numen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ConsoleDebugger is the front end of numen debug, it reads commands from
+// a terminal
+type ConsoleDebugger struct {
+	in   *bufio.Reader
+	out  io.Writer
+	last string // resume command repeated by an empty line
+}
+
+func NewConsoleDebugger(in *bufio.Reader, out io.Writer) *ConsoleDebugger {
+	return &ConsoleDebugger{in: in, out: out, last: "step"}
+}
+
+const console_help = `step, s              run to the next word, entering blocks and words
+next, n              run to the next word in this frame
+finish, f            run until this frame returns
+continue, c          run to the next breakpoint
+break, b [file:]line [if condition]
+                     stop at line, when the Numen condition leaves true
+delete, d id         delete a breakpoint
+breakpoints, bl      list breakpoints
+print, p code        run code on a copy of the stack and show what it leaves
+where, w             show the frames
+show                 show the word, stack and scope again
+quit, q              stop the script
+an empty line repeats the last step, next, finish or continue`
+
+func (c *ConsoleDebugger) Stopped(dbg *Debugger, reason string) {
+	fmt.Fprintf(c.out, "stopped (%v)\n", reason)
+	c.show(dbg)
+	for {
+		fmt.Fprint(c.out, "(numen) ")
+		line, err := c.in.ReadString('\n')
+		if err != nil && line == "" {
+			// no more commands, let the script finish
+			fmt.Fprintln(c.out)
+			dbg.ClearBreakpoints("")
+			dbg.Continue()
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = c.last
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		argument = strings.TrimSpace(argument)
+		switch command {
+		case "step", "s":
+			c.last = "step"
+			dbg.Step()
+			return
+		case "next", "n":
+			c.last = "next"
+			dbg.Next()
+			return
+		case "finish", "f":
+			c.last = "finish"
+			dbg.Finish()
+			return
+		case "continue", "c":
+			c.last = "continue"
+			dbg.Continue()
+			return
+		case "quit", "q":
+			dbg.Quit()
+			return
+		case "break", "b":
+			c.add_breakpoint(dbg, argument)
+		case "delete", "d":
+			id, err := strconv.Atoi(argument)
+			if err != nil || !dbg.RemoveBreakpoint(id) {
+				fmt.Fprintf(c.out, "no breakpoint %q\n", argument)
+			}
+		case "breakpoints", "bl":
+			for _, breakpoint := range dbg.Breakpoints() {
+				fmt.Fprintf(c.out, "%v: %v\n", breakpoint.ID, describe_breakpoint(breakpoint))
+			}
+		case "print", "p":
+			result, err := dbg.Evaluate(argument, dbg.top().Scope)
+			if err != nil {
+				fmt.Fprintln(c.out, err)
+			} else {
+				fmt.Fprintln(c.out, display_stack(result))
+			}
+		case "where", "w":
+			frames := dbg.Frames()
+			for ix := len(frames) - 1; ix >= 0; ix-- {
+				fmt.Fprintf(c.out, "  %v at %v\n", frames[ix].Name, frames[ix].Pos)
+			}
+		case "show":
+			c.show(dbg)
+		case "help", "h":
+			fmt.Fprintln(c.out, console_help)
+		default:
+			fmt.Fprintf(c.out, "unknown command %q, try help\n", command)
+		}
+	}
+}
+
+// show prints the word about to run, the stack and the visible variables
+func (c *ConsoleDebugger) show(dbg *Debugger) {
+	frame := dbg.top()
+	fmt.Fprintf(c.out, "%v: %v\n", frame.Pos, frame.Word.Repr())
+	fmt.Fprintf(c.out, "  stack: %v\n", display_stack(globalStack))
+	names, values := Variables(frame.Scope)
+	for ix, name := range names {
+		fmt.Fprintf(c.out, "  %v = %v\n", name, display_nested(values[ix]))
+	}
+}
+
+// add_breakpoint reads [file:]line [if condition]
+func (c *ConsoleDebugger) add_breakpoint(dbg *Debugger, argument string) {
+	location, condition, _ := strings.Cut(argument, " if ")
+	location = strings.TrimSpace(location)
+	file := ""
+	if ix := strings.LastIndex(location, ":"); ix >= 0 {
+		file, location = location[:ix], location[ix+1:]
+	} else if len(dbg.frames) > 0 {
+		file = dbg.top().Pos.File
+	}
+	line, err := strconv.Atoi(location)
+	if err != nil || line < 1 {
+		fmt.Fprintf(c.out, "expected [file:]line, got %q\n", argument)
+		return
+	}
+	breakpoint := dbg.AddBreakpoint(file, line, strings.TrimSpace(condition))
+	fmt.Fprintf(c.out, "breakpoint %v at %v\n", breakpoint.ID, describe_breakpoint(breakpoint))
+}
+
+func describe_breakpoint(breakpoint *Breakpoint) string {
+	description := fmt.Sprintf("%v:%v", breakpoint.File, breakpoint.Line)
+	if breakpoint.Condition != "" {
+		description += " if " + breakpoint.Condition
+	}
+	if breakpoint.Hits > 0 {
+		description += fmt.Sprintf(", hit %v times", breakpoint.Hits)
+	}
+	return description
+}
+
+// display_stack shows a stack the way print shows stack values
+func display_stack(stack IStack) string {
+	return display_nested(PToken{P_STACK, NewVector(stack...)})
+}
@@ -0,0 +1,321 @@
+package numen
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Debugger stops a script before words and hands control to a front end,
+// which inspects the state and picks how to resume.
+type Debugger struct {
+	Frontend DebugFrontend
+
+	frames      []*Frame
+	breakpoints []*Breakpoint
+	next_id     int
+	line        Position // the last word run, a breakpoint hits once per line
+
+	mode       stepMode
+	mode_depth int  // frame count when step, next or finish was asked for
+	evaluating bool // running an expression for the front end, never stop
+	stopped    bool // already stopped before the current word
+	pausing    bool // the next stop was asked for by Pause
+	quit       bool
+}
+
+// DebugFrontend is told about every stop. Stopped returns once the user
+// resumed the debugger with Step, Next, Finish, Continue or Quit.
+type DebugFrontend interface {
+	Stopped(dbg *Debugger, reason string)
+}
+
+// DebugPoller is a DebugFrontend that also takes requests while the script
+// runs, Poll is called before every word
+type DebugPoller interface {
+	Poll(dbg *Debugger)
+}
+
+// Frame is one run_function call: the program, a block or a word body
+type Frame struct {
+	Name  string   // word that started the frame
+	Start Position // where the frame's code starts
+	Pos   Position // word about to run
+	Word  PWord
+	Scope *IScope
+}
+
+// Breakpoint stops before the first word run on a line, when Condition is
+// set it is Numen code that must leave true on the stack
+type Breakpoint struct {
+	ID        int
+	File      string // empty matches every file
+	Line      int
+	Condition string
+	Hits      int
+}
+
+type stepMode int
+
+const (
+	mode_run    stepMode = iota
+	mode_step            // stop at the next word
+	mode_next            // stop at the next word in this frame or an outer one
+	mode_finish          // stop once this frame returned
+)
+
+// debug_pos is where expressions typed into the debugger start
+var debug_pos = Position{"<debug>", 1, 1}
+
+// NewDebugger returns a debugger that stops before the first word
+func NewDebugger(frontend DebugFrontend) *Debugger {
+	return &Debugger{Frontend: frontend, mode: mode_step}
+}
+
+func WithDebugger(dbg *Debugger) Option {
+	return func(in *Interpreter) {
+		in.Debugger = dbg
+	}
+}
+
+// Frames lists the running frames, innermost last
+func (d *Debugger) Frames() []*Frame {
+	return d.frames
+}
+
+func (d *Debugger) top() *Frame {
+	return d.frames[len(d.frames)-1]
+}
+
+// enter_frame is called when run_function starts and returns the call
+// that pops the frame again
+func (d *Debugger) enter_frame(start Position, scope *IScope) func() {
+	if d.evaluating {
+		return func() {}
+	}
+	if d.quit {
+		// a block without words never reaches before
+		panic(new_error("cancelled", start, "[DEBUG] stopped from the debugger"))
+	}
+	name := "main"
+	if len(d.frames) > 0 {
+		name = d.top().Word.Repr()
+	} else {
+		d.line = Position{}
+	}
+	d.frames = append(d.frames, &Frame{Name: name, Start: start, Scope: scope})
+	return func() {
+		d.frames = d.frames[:len(d.frames)-1]
+	}
+}
+
+// before is called by interpret before every word
+func (d *Debugger) before(word PWord) {
+	if d.evaluating {
+		return
+	}
+	if poller, ok := d.Frontend.(DebugPoller); ok {
+		poller.Poll(d)
+	}
+	if d.quit {
+		panic(new_error("cancelled", word.Pos, "[DEBUG] stopped from the debugger"))
+	}
+	d.stopped = false
+	frame := d.top()
+	// blocks and words entered on the same line do not hit its breakpoints again
+	new_line := d.line.Line != word.Pos.Line || d.line.File != word.Pos.File
+	d.line = word.Pos
+	frame.Pos, frame.Word = word.Pos, word
+
+	if new_line {
+		for _, breakpoint := range d.breakpoints {
+			if breakpoint.Line != word.Pos.Line || !same_file(breakpoint.File, word.Pos.File) {
+				continue
+			}
+			hit, err := d.condition(breakpoint.Condition, frame.Scope)
+			if err != nil {
+				d.stop(fmt.Sprintf("breakpoint %v, condition failed: %v", breakpoint.ID, err.Message))
+				return
+			}
+			if hit {
+				breakpoint.Hits++
+				d.stop(fmt.Sprintf("breakpoint %v", breakpoint.ID))
+				return
+			}
+		}
+	}
+	if d.mode == mode_step ||
+		d.mode == mode_next && len(d.frames) <= d.mode_depth ||
+		d.mode == mode_finish && len(d.frames) < d.mode_depth {
+		if d.pausing {
+			d.stop("pause")
+		} else {
+			d.stop("step")
+		}
+	}
+}
+
+// Break stops right away, the breakpoint builtin calls it
+func (d *Debugger) Break(reason string) {
+	if d.evaluating || d.stopped || len(d.frames) == 0 {
+		return
+	}
+	d.stop(reason)
+}
+
+func (d *Debugger) stop(reason string) {
+	d.mode = mode_run
+	d.stopped = true
+	d.Frontend.Stopped(d, reason)
+}
+
+// Step runs to the next word, entering blocks and words
+func (d *Debugger) Step() {
+	d.resume(mode_step)
+}
+
+// Next runs to the next word of the current frame, or of an outer one when
+// the current frame ends first
+func (d *Debugger) Next() {
+	d.resume(mode_next)
+}
+
+// Finish runs until the current frame returns
+func (d *Debugger) Finish() {
+	d.resume(mode_finish)
+}
+
+// Continue runs to the next breakpoint
+func (d *Debugger) Continue() {
+	d.resume(mode_run)
+}
+
+// Pause stops at the next word, for front ends that poll while the script
+// runs
+func (d *Debugger) Pause() {
+	d.resume(mode_step)
+	d.pausing = true
+}
+
+// Quit stops the script at the next word
+func (d *Debugger) Quit() {
+	d.resume(mode_run)
+	d.quit = true
+}
+
+func (d *Debugger) resume(mode stepMode) {
+	d.mode = mode
+	d.mode_depth = len(d.frames)
+	d.pausing = false
+}
+
+// AddBreakpoint stops at line of file, condition may be empty
+func (d *Debugger) AddBreakpoint(file string, line int, condition string) *Breakpoint {
+	d.next_id++
+	breakpoint := &Breakpoint{ID: d.next_id, File: file, Line: line, Condition: condition}
+	d.breakpoints = append(d.breakpoints, breakpoint)
+	return breakpoint
+}
+
+// RemoveBreakpoint deletes the breakpoint with id, false if there is none
+func (d *Debugger) RemoveBreakpoint(id int) bool {
+	for ix, breakpoint := range d.breakpoints {
+		if breakpoint.ID == id {
+			d.breakpoints = append(d.breakpoints[:ix], d.breakpoints[ix+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ClearBreakpoints deletes every breakpoint set in file
+func (d *Debugger) ClearBreakpoints(file string) {
+	var kept []*Breakpoint
+	for _, breakpoint := range d.breakpoints {
+		if !same_file(file, breakpoint.File) {
+			kept = append(kept, breakpoint)
+		}
+	}
+	d.breakpoints = kept
+}
+
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	return d.breakpoints
+}
+
+// Evaluate runs code in a scope below scope, on a copy of the stack, and
+// returns the stack it leaves. The script's stack is not changed.
+func (d *Debugger) Evaluate(code string, scope *IScope) (result IStack, err *NumenError) {
+	saved_stack := globalStack
+	d.evaluating = true
+	defer func() {
+		globalStack = saved_stack
+		d.evaluating = false
+	}()
+	globalStack = append(IStack(nil), saved_stack...)
+	if err := protect(func() {
+		run_function(code, debug_pos, NewScope(scope))
+	}); err != nil {
+		return globalStack, err.(*NumenError)
+	}
+	return globalStack, nil
+}
+
+func (d *Debugger) condition(code string, scope *IScope) (bool, *NumenError) {
+	if code == "" {
+		return true, nil
+	}
+	result, err := d.Evaluate(code, scope)
+	if err != nil {
+		return false, err
+	}
+	if len(result) == 0 || result[len(result)-1].Type != P_BOOLEAN {
+		return false, new_error("error", debug_pos, "[DEBUG] condition must leave a Boolean on the stack")
+	}
+	return result[len(result)-1].Value.(bool), nil
+}
+
+// same_file reports whether a breakpoint for pattern applies to file, a
+// pattern without a directory matches the file name alone
+func same_file(pattern string, file string) bool {
+	if pattern == "" || pattern == file {
+		return true
+	}
+	if !strings.ContainsRune(pattern, filepath.Separator) && filepath.Base(file) == pattern {
+		return true
+	}
+	abs_pattern, err1 := filepath.Abs(pattern)
+	abs_file, err2 := filepath.Abs(file)
+	return err1 == nil && err2 == nil && abs_pattern == abs_file
+}
+
+// Variables lists the bindings visible from scope, innermost scopes first
+// and shadowed bindings left out
+func Variables(scope *IScope) (names []string, values []PToken) {
+	seen := map[string]bool{}
+	for ; scope != nil; scope = scope.parent {
+		var level []string
+		for name := range scope.vars {
+			if !seen[name] {
+				seen[name] = true
+				level = append(level, name)
+			}
+		}
+		sort.Strings(level)
+		for _, name := range level {
+			names = append(names, name)
+			values = append(values, scope.vars[name])
+		}
+	}
+	return names, values
+}
+
+var debugBuiltins = map[string]func(){
+	"breakpoint": func() {
+		// stops here when running under the debugger, does nothing otherwise
+		if interp.Debugger != nil {
+			interp.Debugger.Break("breakpoint word")
+		}
+	},
+}
@@ -0,0 +1,40 @@
+package numen
+
+import (
+	"slices"
+	"testing"
+)
+
+// continuer resumes after every stop and keeps the reasons
+type continuer struct {
+	reasons []string
+}
+
+func (c *continuer) Stopped(dbg *Debugger, reason string) {
+	c.reasons = append(c.reasons, reason)
+	dbg.Continue()
+}
+
+func TestBreakpointHitsOncePerLine(t *testing.T) {
+	frontend := &continuer{}
+	dbg := NewDebugger(frontend)
+	dbg.Continue()
+	first := dbg.AddBreakpoint("", 1, "")
+	second := dbg.AddBreakpoint("", 2, "")
+	in := NewInterpreter(WithDebugger(dbg))
+	for run := 1; run <= 2; run++ {
+		if _, err := in.Run("debug.nm", "1 { 2 } run : two 2 ; two\n3 { 4 { 5 } run } run"); err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for range run {
+			want = append(want, "breakpoint 1", "breakpoint 2")
+		}
+		if !slices.Equal(frontend.reasons, want) {
+			t.Errorf("run %v stopped for %q, want %q", run, frontend.reasons, want)
+		}
+	}
+	if first.Hits != 2 || second.Hits != 2 {
+		t.Errorf("the breakpoints were hit %v and %v times, want 2 each", first.Hits, second.Hits)
+	}
+}
@@ -0,0 +1,164 @@
+package numen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Go programs run scripts through an Interpreter. The data stack, scopes
+// and dictionary are still globals, so only one script runs at a time.
+
+// Builtin is a word written in Go, it works on the data stack directly
+type Builtin func(stack *IStack)
+
+// WithBuiltin adds a Go word, or stubs out the builtin of the same name.
+// Words the script defines still shadow it.
+func WithBuiltin(name string, builtin Builtin) Option {
+	return func(in *Interpreter) {
+		if in.host_builtins == nil {
+			in.host_builtins = map[string]Builtin{}
+		}
+		in.host_builtins[name] = builtin
+	}
+}
+
+// use makes in the interpreter builtins run against, until the returned
+// call puts the previous one back
+func (in *Interpreter) use() func() {
+	saved := interp
+	interp = in
+	in.steps = 0
+	return func() {
+		interp = saved
+	}
+}
+
+// Run runs code as file on fresh globals, with stack pushed first, bottom
+// first. It returns the stack the code leaves, also when it failed.
+func (in *Interpreter) Run(file string, code string, stack ...PToken) (IStack, error) {
+	defer in.use()()
+	reset_globals()
+	in.loading = nil
+	if abs, err := filepath.Abs(file); err == nil {
+		in.loading = append(in.loading, abs)
+	}
+	globalStack = append(globalStack, stack...)
+	err := protect(func() {
+		run_function(code, Position{file, 1, 1}, globalScope)
+	})
+	return append(IStack(nil), globalStack...), err
+}
+
+// RunFile runs the script at path like Run
+func (in *Interpreter) RunFile(path string, stack ...PToken) (IStack, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return in.Run(path, string(code), stack...)
+}
+
+// FindTestFiles lists the *_test.nm files in paths, directories are
+// searched recursively
+func FindTestFiles(paths ...string) ([]string, error) {
+	return find_test_files(paths)
+}
+
+// TestFile is a *_test.nm file whose top level ran, ready to run its tests
+type TestFile struct {
+	in    *Interpreter
+	path  string
+	tests []testCase
+	state testState
+}
+
+// LoadTestFile runs the top level of the test file at path once, like
+// numen test does. Its tests run from what the top level left.
+func (in *Interpreter) LoadTestFile(path string) (*TestFile, error) {
+	defer in.use()()
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := load_test_file(path, string(code)); err != nil {
+		return nil, err
+	}
+	return &TestFile{in: in, path: path, tests: test_cases(), state: save_test_state()}, nil
+}
+
+// Names lists the test functions of the file in source order
+func (file *TestFile) Names() []string {
+	var names []string
+	for _, test := range file.tests {
+		names = append(names, test.name)
+	}
+	return names
+}
+
+// Run runs the test function name on an empty stack, with the variables and
+// words as the top level left them. It returns the stack the test leaves.
+func (file *TestFile) Run(name string) (IStack, error) {
+	defer file.in.use()()
+	for _, test := range file.tests {
+		if test.name == name {
+			file.state.restore()
+			err := run_test(test)
+			return append(IStack(nil), globalStack...), err
+		}
+	}
+	return nil, fmt.Errorf("%v has no test %v", file.path, name)
+}
+
+// Value converts a Go value to a token: integers, floats, strings and
+// booleans, []any as a stack and map[string]any as a memory. Tokens, lists
+// and memories are taken as they are.
+func Value(value any) PToken {
+	switch value := value.(type) {
+	case PToken:
+		return value
+	case int:
+		return PToken{P_INT, int64(value)}
+	case int64:
+		return PToken{P_INT, value}
+	case float64:
+		return PToken{P_FLOAT, value}
+	case string:
+		return PToken{P_STRING, value}
+	case bool:
+		return PToken{P_BOOLEAN, value}
+	case IList:
+		return PToken{P_STACK, value}
+	case IMemory:
+		return PToken{P_MEMORY, value}
+	case []any:
+		var list IList
+		for _, item := range value {
+			list = list.Append(Value(item))
+		}
+		return PToken{P_STACK, list}
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		memory := NewMemory()
+		for _, key := range keys {
+			memory = memory.Set(key, Value(value[key]))
+		}
+		return PToken{P_MEMORY, memory}
+	}
+	panic(fmt.Sprintf("numen: cannot convert %T to a value", value))
+}
+
+// ParseValues parses Numen literals, such as `1 "two" ( 3 )`, into a stack
+func ParseValues(code string) (values IStack, err error) {
+	if err := protect(func() {
+		values = parser_collect(code, Position{"<values>", 1, 1})
+	}); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
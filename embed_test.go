@@ -0,0 +1,62 @@
+package numen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResultStacksAreCopies(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"copy_test.nm": "{ 1 2 } 'test_pair store",
+	})
+	in := NewInterpreter()
+	from_run, _ := in.Run("copy.nm", "1 2")
+	if &from_run[0] == &globalStack[0] {
+		t.Error("Run returned the interpreter's own stack")
+	}
+	file, err := in.LoadTestFile(filepath.Join(dir, "copy_test.nm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from_test, err := file.Run("test_pair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &from_test[0] == &globalStack[0] {
+		t.Error("TestFile.Run returned the interpreter's own stack")
+	}
+}
+
+func TestLoadTestFileRunsTopLevelOnce(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"count_test.nm": `
+			"x" "log.txt" appendfile
+			0 'count store
+			{ count 1 + 'count set count } 'test_first store
+			{ count 1 + 'count set count } 'test_second store
+		`,
+		"other_test.nm": `5 'count store { count } 'test_other store`,
+	})
+	in := NewInterpreter(WithRoot(dir))
+	file, err := in.LoadTestFile(filepath.Join(dir, "count_test.nm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// loading another file in between must not change what the tests see
+	if _, err := in.LoadTestFile(filepath.Join(dir, "other_test.nm")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range file.Names() {
+		stack, err := file.Run(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !list_of(stack...).Equal(list_of(integer(1))) {
+			t.Errorf("%v left %v, want every test to start from count 0", name, list_of(stack...).Repr())
+		}
+	}
+	if log, _ := os.ReadFile(filepath.Join(dir, "log.txt")); string(log) != "x" {
+		t.Errorf("the top level ran %v times, want once", len(log))
+	}
+}
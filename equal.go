@@ -0,0 +1,157 @@
+package numen
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"math"
+)
+
+// Equal reports whether two tokens hold the same value. Stacks and memories
+// are compared recursively, integers and floats are never equal to each other.
+func (token PToken) Equal(other PToken) bool {
+	return values_equal(token, other, false)
+}
+
+// NumEqual is Equal with integers and floats compared by numeric value,
+// so (1) and (1.0) are equal.
+func (token PToken) NumEqual(other PToken) bool {
+	return values_equal(token, other, true)
+}
+
+func is_number(token PToken) bool {
+	return token.Type == P_INT || token.Type == P_FLOAT
+}
+
+func values_equal(first PToken, second PToken, numeric bool) bool {
+	if numeric && is_number(first) && is_number(second) && first.Type != second.Type {
+		return to_float(first) == to_float(second)
+	}
+	if first.Type != second.Type {
+		return false
+	}
+	if first.Type == P_INT {
+		return first.Value.(int64) == second.Value.(int64)
+	} else if first.Type == P_FLOAT {
+		return first.Value.(float64) == second.Value.(float64)
+	} else if Contains(first.Type, P_STRING, P_SYMBOL) {
+		return first.Value.(string) == second.Value.(string)
+	} else if first.Type == P_BLOCK {
+		// blocks compare by code, the captured scope is ignored
+		return first.Value.(IBlock).Code == second.Value.(IBlock).Code
+	} else if first.Type == P_BOOLEAN {
+		return first.Value.(bool) == second.Value.(bool)
+	} else if first.Type == P_TYPE_LITERAL {
+		return first.Value.(TypeLiterals) == second.Value.(TypeLiterals)
+	} else if first.Type == P_EOF {
+		return true
+	} else if first.Type == P_STACK {
+		fstack := first.Value.(IList)
+		sstack := second.Value.(IList)
+		if fstack.Len() != sstack.Len() {
+			return false
+		}
+		for ix := 0; ix < fstack.Len(); ix++ {
+			if !values_equal(fstack.Get(ix), sstack.Get(ix), numeric) {
+				return false
+			}
+		}
+		return true
+	} else if first.Type == P_MEMORY {
+		fmemory := first.Value.(IMemory)
+		smemory := second.Value.(IMemory)
+		if fmemory.Len() != smemory.Len() {
+			return false
+		}
+		for _, key := range fmemory.Keys() {
+			fvalue, _ := fmemory.Get(key)
+			svalue, ok := smemory.Get(key)
+			if !ok || !values_equal(fvalue, svalue, numeric) {
+				return false
+			}
+		}
+		return true
+	}
+	panicf("[EQUAL] cannot compare types %v", first.Type)
+	return false
+}
+
+func to_float(token PToken) float64 {
+	if token.Type == P_INT {
+		return float64(token.Value.(int64))
+	}
+	return token.Value.(float64)
+}
+
+// Hash returns a canonical hash of the token's value. Values that are Equal
+// or NumEqual always hash the same: integral floats hash like the matching
+// integer, integers beyond 2^53 hash like the float NumEqual rounds them to
+// and memories hash independently of key order.
+func (token PToken) Hash() uint64 {
+	if token.Type == P_INT {
+		value := token.Value.(int64)
+		if value > max_exact_int || value < -max_exact_int {
+			return PToken{P_FLOAT, float64(value)}.Hash()
+		}
+		return hash_integer(value)
+	} else if token.Type == P_FLOAT {
+		fvalue := token.Value.(float64)
+		if fvalue == math.Trunc(fvalue) && math.Abs(fvalue) < 1<<63 {
+			return hash_integer(int64(fvalue))
+		}
+	}
+
+	hasher := fnv.New64a()
+	hasher.Write([]byte{byte(token.Type)})
+	if token.Type == P_FLOAT {
+		write_uint64(hasher, math.Float64bits(token.Value.(float64)))
+	} else if Contains(token.Type, P_STRING, P_SYMBOL) {
+		hasher.Write([]byte(token.Value.(string)))
+	} else if token.Type == P_BLOCK {
+		hasher.Write([]byte(token.Value.(IBlock).Code))
+	} else if token.Type == P_BOOLEAN {
+		if token.Value.(bool) {
+			hasher.Write([]byte{1})
+		} else {
+			hasher.Write([]byte{0})
+		}
+	} else if token.Type == P_TYPE_LITERAL {
+		hasher.Write([]byte{byte(token.Value.(TypeLiterals))})
+	} else if token.Type == P_EOF {
+		// there is only one end of input, its type is the whole value
+	} else if token.Type == P_STACK {
+		token.Value.(IList).Each(func(_ int, item PToken) {
+			write_uint64(hasher, item.Hash())
+		})
+	} else if token.Type == P_MEMORY {
+		// entries are combined with a sum so the hash ignores key order
+		var sum uint64
+		token.Value.(IMemory).Each(func(key string, value PToken) {
+			entry := fnv.New64a()
+			entry.Write([]byte(key))
+			write_uint64(entry, value.Hash())
+			sum += entry.Sum64()
+		})
+		write_uint64(hasher, sum)
+	} else {
+		panicf("[HASH] cannot hash type %v", token.Type)
+	}
+	return hasher.Sum64()
+}
+
+// max_exact_int is the largest integer every smaller one converts to a
+// float exactly
+const max_exact_int = 1 << 53
+
+func hash_integer(value int64) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte{byte(P_INT)})
+	write_uint64(hasher, uint64(value))
+	return hasher.Sum64()
+}
+
+func write_uint64(writer io.Writer, value uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], value)
+	writer.Write(buf[:])
+}
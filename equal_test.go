@@ -0,0 +1,97 @@
+package numen
+
+import (
+	"math"
+	"testing"
+)
+
+func list_of(items ...PToken) PToken {
+	return PToken{P_STACK, NewVector(items...)}
+}
+
+func memory_of(pairs ...any) PToken {
+	memory := NewMemory()
+	for ix := 0; ix < len(pairs); ix += 2 {
+		memory = memory.Set(pairs[ix].(string), pairs[ix+1].(PToken))
+	}
+	return PToken{P_MEMORY, memory}
+}
+
+func integer(value int64) PToken { return PToken{P_INT, value} }
+func float(value float64) PToken { return PToken{P_FLOAT, value} }
+func text(value string) PToken   { return PToken{P_STRING, value} }
+
+func equality_values() []PToken {
+	return []PToken{
+		integer(0), integer(1), integer(-1), integer(math.MaxInt64),
+		float(0), float(1), float(-1), float(1.5), float(math.Inf(1)), float(1 << 62),
+		// NumEqual compares these through float64, which rounds the integers
+		integer(1<<53 + 1), integer(-(1 << 53) - 1), integer(math.MinInt64),
+		float(1 << 53), float(-(1 << 53)), float(1 << 63), float(-(1 << 63)),
+		text(""), text("1"), text("a"),
+		{P_SYMBOL, "a"},
+		{P_BOOLEAN, true}, {P_BOOLEAN, false},
+		list_of(), list_of(integer(1)), list_of(float(1)), list_of(integer(1), text("a")),
+		list_of(list_of(integer(2))), list_of(list_of(float(2))),
+		memory_of(), memory_of("a", integer(1)), memory_of("a", float(1)),
+		memory_of("a", integer(1), "b", text("x")), memory_of("b", text("x"), "a", integer(1)),
+		memory_of("a", list_of(integer(3))), memory_of("a", list_of(float(3))),
+	}
+}
+
+func TestEqualImpliesSameHash(t *testing.T) {
+	values := equality_values()
+	for _, a := range values {
+		for _, b := range values {
+			if a.Equal(b) && a.Hash() != b.Hash() {
+				t.Errorf("%v and %v are Equal but hash differently", a.Repr(), b.Repr())
+			}
+			if a.NumEqual(b) && a.Hash() != b.Hash() {
+				t.Errorf("%v and %v are NumEqual but hash differently", a.Repr(), b.Repr())
+			}
+			if a.Equal(b) && !a.NumEqual(b) {
+				t.Errorf("%v and %v are Equal but not NumEqual", a.Repr(), b.Repr())
+			}
+		}
+	}
+}
+
+func TestEqualNumbers(t *testing.T) {
+	tests := []struct {
+		a, b      PToken
+		equal     bool
+		num_equal bool
+	}{
+		{integer(1), integer(1), true, true},
+		{integer(1), float(1), false, true},
+		{integer(1), float(1.5), false, false},
+		{integer(1<<53 + 1), float(1 << 53), false, true},
+		{integer(math.MaxInt64), float(1 << 63), false, true},
+		{float(1.5), float(1.5), true, true},
+		{integer(1), text("1"), false, false},
+		{list_of(integer(1)), list_of(float(1)), false, true},
+		{memory_of("a", integer(2)), memory_of("a", float(2)), false, true},
+		{memory_of("a", integer(1), "b", integer(2)), memory_of("b", integer(2), "a", integer(1)), true, true},
+		{memory_of("a", integer(1)), memory_of("b", integer(1)), false, false},
+		{list_of(integer(1)), list_of(integer(1), integer(1)), false, false},
+	}
+	for _, test := range tests {
+		if got := test.a.Equal(test.b); got != test.equal {
+			t.Errorf("%v Equal %v = %v, want %v", test.a.Repr(), test.b.Repr(), got, test.equal)
+		}
+		if got := test.a.NumEqual(test.b); got != test.num_equal {
+			t.Errorf("%v NumEqual %v = %v, want %v", test.a.Repr(), test.b.Repr(), got, test.num_equal)
+		}
+	}
+}
+
+func TestIntegralFloatsHashLikeIntegers(t *testing.T) {
+	for _, value := range []int64{0, 1, -1, 42, 1 << 53, -(1 << 62)} {
+		if integer(value).Hash() != float(float64(value)).Hash() {
+			t.Errorf("%v and %v.0 hash differently", value, value)
+		}
+	}
+	if integer(1).Hash() == float(1.5).Hash() {
+		t.Errorf("1 and 1.5 hash the same")
+	}
+}
@@ -0,0 +1,152 @@
+package numen
+
+import (
+	"fmt"
+	"os"
+)
+
+// Position is a place in a source file, lines and columns count from 1
+type Position struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (pos Position) String() string {
+	if pos.Line == 0 {
+		return pos.File
+	}
+	if pos.File == "" {
+		return fmt.Sprintf("%v:%v", pos.Line, pos.Col)
+	}
+	return fmt.Sprintf("%v:%v:%v", pos.File, pos.Line, pos.Col)
+}
+
+// NumenError is an error raised while running a script, try can catch it
+type NumenError struct {
+	Kind    string // throw, error or syntax
+	Message string
+	Pos     Position
+	Payload PToken // the thrown value, the message for other kinds
+}
+
+func (err *NumenError) Error() string {
+	message := err.Message
+	if err.Kind == "throw" {
+		message = "uncaught throw: " + message
+	}
+	if err.Pos.Line == 0 && err.Pos.File == "" {
+		return message
+	}
+	return fmt.Sprintf("%v: %v", err.Pos, message)
+}
+
+// Memory is the error as the handler of try receives it
+func (err *NumenError) Memory() IMemory {
+	return NewMemory().
+		Set("kind", PToken{P_STRING, err.Kind}).
+		Set("message", PToken{P_STRING, err.Message}).
+		Set("position", PToken{P_STRING, err.Pos.String()}).
+		Set("payload", err.Payload)
+}
+
+func new_error(kind string, pos Position, message string) *NumenError {
+	return &NumenError{Kind: kind, Message: message, Pos: pos, Payload: PToken{P_STRING, message}}
+}
+
+// as_error turns a recovered panic into a NumenError at the current word.
+// Break is control flow and is returned unchanged.
+func as_error(r any) any {
+	if r == nil || r == "BREAK" {
+		return r
+	}
+	if err, ok := r.(*NumenError); ok {
+		return err
+	}
+	return new_error("error", currentPos, fmt.Sprint(r))
+}
+
+func syntax_error(pos Position, message string) *NumenError {
+	return new_error("syntax", pos, message)
+}
+
+func as_syntax_error(r any, pos Position) *NumenError {
+	if r == nil {
+		return nil
+	}
+	if err, ok := r.(*NumenError); ok {
+		return err
+	}
+	return syntax_error(pos, fmt.Sprint(r))
+}
+
+// exit_on_error reports an uncaught error and stops the process, it must
+// be deferred.
+func exit_on_error() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if err, ok := as_error(r).(*NumenError); ok {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	panic(r)
+}
+
+// protect runs fn and returns the error it raised, always a *NumenError.
+// break is passed on.
+func protect(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			caught, ok := as_error(r).(*NumenError)
+			if !ok {
+				panic(r)
+			}
+			err = caught
+		}
+	}()
+	fn()
+	return nil
+}
+
+var errorBuiltins = map[string]func(){
+	"throw": func() {
+		// ( value -- ) an error memory from try keeps its kind, message and payload
+		value := globalStack.PopAny()
+		if value.Type == P_MEMORY {
+			memory := value.Value.(IMemory)
+			kind, has_kind := memory.Get("kind")
+			message, has_message := memory.Get("message")
+			payload, has_payload := memory.Get("payload")
+			if has_kind && has_message && has_payload && kind.Type == P_STRING && message.Type == P_STRING {
+				panic(&NumenError{Kind: kind.Value.(string), Message: message.Value.(string), Pos: currentPos, Payload: payload})
+			}
+		}
+		panic(&NumenError{Kind: "throw", Message: value.Display(), Pos: currentPos, Payload: value})
+	},
+	"try": func() {
+		// Syntax: { body } { handler } try
+		// the handler runs with the stack as it was before the body and the
+		// error memory pushed
+		handler := globalStack.PopBlock()
+		body := globalStack.PopBlock()
+		saved_stack := append(IStack(nil), globalStack...)
+		err := protect(func() {
+			run_block(body)
+		})
+		if err == nil {
+			return
+		}
+		globalStack = append(saved_stack, PToken{P_MEMORY, err.(*NumenError).Memory()})
+		run_block(handler)
+	},
+	"finally": func() {
+		// Syntax: { body } { cleanup } finally
+		// cleanup always runs, errors from the body are raised after it
+		cleanup := globalStack.PopBlock()
+		body := globalStack.PopBlock()
+		defer run_block(cleanup)
+		run_block(body)
+	},
+}
@@ -0,0 +1,240 @@
+package numen
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Display renders the value the way print shows it: strings without quotes,
+// stacks as ( 1 2 3 ) and memories as [ key value ]. Strings nested inside
+// stacks and memories keep their quotes.
+func (token PToken) Display() string {
+	if token.Type == P_STRING {
+		return token.Value.(string)
+	}
+	return display_nested(token)
+}
+
+func display_nested(token PToken) string {
+	if token.Type == P_INT {
+		return strconv.FormatInt(token.Value.(int64), 10)
+	} else if token.Type == P_FLOAT {
+		return strconv.FormatFloat(token.Value.(float64), 'g', -1, 64)
+	} else if token.Type == P_STRING {
+		return repr_string(token.Value.(string))
+	} else if token.Type == P_BOOLEAN {
+		return strconv.FormatBool(token.Value.(bool))
+	} else if token.Type == P_BLOCK {
+		return "{ " + token.Value.(IBlock).Code + " }"
+	} else if token.Type == P_STACK {
+		var builder strings.Builder
+		builder.WriteString("(")
+		token.Value.(IList).Each(func(_ int, item PToken) {
+			builder.WriteString(" " + display_nested(item))
+		})
+		builder.WriteString(" )")
+		return builder.String()
+	} else if token.Type == P_MEMORY {
+		var builder strings.Builder
+		builder.WriteString("[")
+		token.Value.(IMemory).Each(func(key string, value PToken) {
+			builder.WriteString(" " + key + " " + display_nested(value))
+		})
+		builder.WriteString(" ]")
+		return builder.String()
+	}
+	return fmt.Sprint(token.Value)
+}
+
+// Repr renders the value as Numen source, parsing the result gives back an
+// Equal value.
+func (token PToken) Repr() string {
+	if token.Type == P_INT {
+		return strconv.FormatInt(token.Value.(int64), 10)
+	} else if token.Type == P_FLOAT {
+		fvalue := token.Value.(float64)
+		if math.IsNaN(fvalue) || math.IsInf(fvalue, 0) {
+			panicf("[REPR] cannot represent float %v", fvalue)
+		}
+		result := strconv.FormatFloat(fvalue, 'f', -1, 64)
+		if !strings.Contains(result, ".") {
+			// the parser needs a dot to read a float
+			result += ".0"
+		}
+		return result
+	} else if token.Type == P_STRING {
+		return repr_string(token.Value.(string))
+	} else if token.Type == P_BLOCK {
+		code := token.Value.(IBlock).Code
+		if code == "" {
+			return "{ }"
+		}
+		return "{ " + code + " }"
+	} else if token.Type == P_STACK {
+		var builder strings.Builder
+		builder.WriteString("(")
+		token.Value.(IList).Each(func(_ int, item PToken) {
+			builder.WriteString(" " + item.Repr())
+		})
+		builder.WriteString(" )")
+		return builder.String()
+	} else if token.Type == P_MEMORY {
+		var builder strings.Builder
+		builder.WriteString("[")
+		token.Value.(IMemory).Each(func(key string, value PToken) {
+			builder.WriteString(" " + repr_key(key) + " " + value.Repr())
+		})
+		builder.WriteString(" ]")
+		return builder.String()
+	} else if token.Type == P_SYMBOL {
+		return repr_symbol(token.Value.(string))
+	} else if token.Type == P_EOF {
+		panicf("[REPR] cannot represent EOF, only the read words push it")
+	}
+	// booleans and type literals are written as their name
+	return fmt.Sprint(token.Value)
+}
+
+var string_escaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"\"", "\\\"",
+	"\n", "\\n",
+	"\t", "\\t",
+	"\r", "\\r",
+)
+
+func repr_string(value string) string {
+	return "\"" + string_escaper.Replace(value) + "\""
+}
+
+// repr_symbol quotes a symbol as 'name, or as '"name" when the parser
+// would split the name
+func repr_symbol(name string) string {
+	if name == "" || strings.ContainsFunc(name, splits_word) {
+		return "'" + repr_string(name)
+	}
+	return "'" + name
+}
+
+// repr_key writes a memory key bare when it parses back as the same symbol
+func repr_key(key string) string {
+	if key == "" || strings.ContainsFunc(key, splits_word) || strings.ContainsAny(key[:1], "':") {
+		return repr_symbol(key)
+	}
+	has_letter := strings.ContainsFunc(key, unicode.IsLetter)
+	has_digit := strings.ContainsFunc(key, unicode.IsDigit)
+	_, bool_err := strconv.ParseBool(key)
+	if has_digit && !has_letter || bool_err == nil {
+		return repr_symbol(key)
+	}
+	for _, name := range TypeLiteralStr {
+		if key == name || key == strings.ToUpper(name) {
+			return repr_symbol(key)
+		}
+	}
+	return key
+}
+
+// splits_word is true for characters that end a word or start a literal
+func splits_word(char rune) bool {
+	return unicode.IsSpace(char) || strings.ContainsRune("{}()[]\"/", char)
+}
+
+// parse_value parses code holding exactly one value literal
+func parse_value(code string) PToken {
+	parsed := parser_collect(code, Position{})
+	if len(parsed) != 1 {
+		panicf("[PARSE] expected a single value, got %v", len(parsed))
+	}
+	return parsed[0]
+}
+
+// a literal piece of a format string or a single % verb
+type formatPart struct {
+	literal string
+	verb    rune
+	spec    string // flags, width and precision without the verb
+}
+
+func parse_format(format string) (parts []formatPart) {
+	runes := []rune(format)
+	var literal []rune
+	for ix := 0; ix < len(runes); ix++ {
+		if runes[ix] != '%' {
+			literal = append(literal, runes[ix])
+			continue
+		}
+		ix++
+		if ix < len(runes) && runes[ix] == '%' {
+			literal = append(literal, '%')
+			continue
+		}
+		start := ix
+		for ix < len(runes) && strings.ContainsRune("-+ 0#", runes[ix]) {
+			ix++
+		}
+		for ix < len(runes) && (runes[ix] >= '0' && runes[ix] <= '9' || runes[ix] == '.') {
+			ix++
+		}
+		if ix >= len(runes) {
+			panicf("[FORMAT] unfinished verb at end of %q", format)
+		}
+		if !strings.ContainsRune("dfsv", runes[ix]) {
+			panicf("[FORMAT] unknown verb %%%c", runes[ix])
+		}
+		if len(literal) > 0 {
+			parts = append(parts, formatPart{literal: string(literal)})
+			literal = nil
+		}
+		parts = append(parts, formatPart{verb: runes[ix], spec: string(runes[start:ix])})
+	}
+	if len(literal) > 0 {
+		parts = append(parts, formatPart{literal: string(literal)})
+	}
+	return parts
+}
+
+// format_from_stack pops the format string and one argument per verb, the
+// deepest argument fills the first verb.
+func format_from_stack(name string) string {
+	format := globalStack.PopString()
+	parts := parse_format(format)
+	var verbs []formatPart
+	for _, part := range parts {
+		if part.verb != 0 {
+			verbs = append(verbs, part)
+		}
+	}
+	if len(globalStack) < len(verbs) {
+		panicf("[%v] format needs %v arguments, stack has %v", name, len(verbs), len(globalStack))
+	}
+	args := make([]PToken, len(verbs))
+	for ix := len(args) - 1; ix >= 0; ix-- {
+		args[ix] = globalStack.PopAny()
+	}
+
+	var builder strings.Builder
+	arg_ix := 0
+	for _, part := range parts {
+		if part.verb == 0 {
+			builder.WriteString(part.literal)
+			continue
+		}
+		arg := args[arg_ix]
+		arg_ix++
+		spec := "%" + part.spec
+		if part.verb == 'd' {
+			assert(arg.Type == P_INT, "[%v] %%d expects Integer, got %v", name, arg.Type)
+			builder.WriteString(fmt.Sprintf(spec+"d", arg.Value.(int64)))
+		} else if part.verb == 'f' {
+			assert(is_number(arg), "[%v] %%f expects a number, got %v", name, arg.Type)
+			builder.WriteString(fmt.Sprintf(spec+"f", to_float(arg)))
+		} else {
+			builder.WriteString(fmt.Sprintf(spec+"s", arg.Display()))
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,37 @@
+package numen
+
+import "testing"
+
+func symbol(name string) PToken { return PToken{P_SYMBOL, name} }
+
+func TestReprRoundTrip(t *testing.T) {
+	values := []PToken{
+		integer(0), integer(-42), integer(9007199254740993),
+		float(0), float(1), float(-2.5), float(1e-7), float(1e21),
+		text(""), text("plain"), text("quote \" and \\ backslash"), text("tab\tnew\nline\r"), text("{ ( [ // ] ) }"),
+		{P_BOOLEAN, true}, {P_BOOLEAN, false},
+		symbol("name"), symbol("true"), symbol("f"), symbol("t"), symbol("int"), symbol("ANY"),
+		symbol("123"), symbol("1.5"), symbol("a b"), symbol(""), symbol("'x"), symbol(":x"),
+		symbol("{"), symbol("a)b"), symbol("quote\"d"), symbol("a/b"), symbol("dotted.name"),
+		{P_BLOCK, IBlock{Code: ""}}, {P_BLOCK, IBlock{Code: `1 2 + "}" println`}},
+		list_of(), list_of(integer(1), float(1), text("1"), symbol("1"), symbol("x")),
+		list_of(list_of(list_of(symbol("true")))),
+		memory_of(), memory_of("key", integer(1)),
+		memory_of("true", integer(1), "int", integer(2), "12", integer(3), "a b", integer(4), "'q", integer(5)),
+		memory_of("nested", memory_of("x", list_of(symbol("f"), PToken{P_BLOCK, IBlock{Code: "dup"}}))),
+	}
+	for literal := range TypeLiteralStr {
+		values = append(values, PToken{P_TYPE_LITERAL, literal})
+	}
+	for _, value := range values {
+		source := value.Repr()
+		var parsed PToken
+		if err := protect(func() { parsed = parse_value(source) }); err != nil {
+			t.Errorf("cannot parse %v back: %v", source, err)
+			continue
+		}
+		if !parsed.Equal(value) || parsed.Type != value.Type {
+			t.Errorf("%v parses as %v %v, want %v", source, parsed.Type, parsed.Repr(), value.Type)
+		}
+	}
+}
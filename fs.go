@@ -0,0 +1,210 @@
+package numen
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func WithRoot(dir string) Option {
+	return func(in *Interpreter) {
+		in.Root = dir
+	}
+}
+
+// root returns the real absolute path of the sandbox root
+func (in *Interpreter) root(name string) string {
+	root, err := filepath.Abs(in.Root)
+	if err != nil {
+		panicf("[%v] bad root %v: %v", name, in.Root, err)
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	return root
+}
+
+func is_within(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Resolve maps a script path onto the host inside Root. Absolute paths are
+// taken relative to Root too. The path is walked one name at a time and
+// symlinks are followed here, so a link that leaves Root panics with a
+// permission error even when its target does not exist yet.
+func (in *Interpreter) Resolve(name string, path string) string {
+	root := in.root(name)
+	joined := filepath.Join(root, path)
+	if !is_within(root, joined) {
+		panicf("[%v] permission denied: %v escapes the root", name, path)
+	}
+	rel, _ := filepath.Rel(root, joined)
+	parts := split_path(rel)
+	current := root
+	for links := 0; len(parts) > 0; {
+		next := filepath.Join(current, parts[0])
+		parts = parts[1:]
+		if !is_within(root, next) {
+			panicf("[%v] permission denied: %v escapes the root", name, path)
+		}
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+		if links++; links > max_links {
+			panicf("[%v] %v: too many levels of symbolic links", name, path)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			fs_error(name, path, err)
+		}
+		if filepath.IsAbs(target) {
+			if !is_within(root, target) {
+				panicf("[%v] permission denied: %v escapes the root", name, path)
+			}
+			target, _ = filepath.Rel(root, target)
+			current = root
+		}
+		// the link is replaced by its target, relative to the link's directory
+		parts = append(split_path(target), parts...)
+	}
+	return current
+}
+
+// max_links is how many symlinks a path may pass, like the limit of Linux
+const max_links = 40
+
+func split_path(path string) []string {
+	var parts []string
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func fs_error(name string, path string, err error) {
+	var path_err *fs.PathError
+	if errors.As(err, &path_err) {
+		err = path_err.Err
+	}
+	panicf("[%v] %v: %v", name, path, err)
+}
+
+var fsBuiltins = map[string]func(){
+	"readfile": func() {
+		// ( path -- content )
+		path := globalStack.PopString()
+		data, err := os.ReadFile(interp.Resolve("READFILE", path))
+		if err != nil {
+			fs_error("READFILE", path, err)
+		}
+		globalStack = append(globalStack, PToken{P_STRING, string(data)})
+	},
+	"writefile": func() {
+		// ( content path -- )
+		path := globalStack.PopString()
+		content := globalStack.PopString()
+		if err := os.WriteFile(interp.Resolve("WRITEFILE", path), []byte(content), 0o644); err != nil {
+			fs_error("WRITEFILE", path, err)
+		}
+	},
+	"appendfile": func() {
+		// ( content path -- )
+		path := globalStack.PopString()
+		content := globalStack.PopString()
+		file, err := os.OpenFile(interp.Resolve("APPENDFILE", path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			fs_error("APPENDFILE", path, err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			fs_error("APPENDFILE", path, err)
+		}
+	},
+	"exists?": func() {
+		// ( path -- bool )
+		path := globalStack.PopString()
+		_, err := os.Stat(interp.Resolve("EXISTS?", path))
+		globalStack = append(globalStack, PToken{P_BOOLEAN, err == nil})
+	},
+	"listdir": func() {
+		// ( path -- names )
+		path := globalStack.PopString()
+		entries, err := os.ReadDir(interp.Resolve("LISTDIR", path))
+		if err != nil {
+			fs_error("LISTDIR", path, err)
+		}
+		var names IList
+		for _, entry := range entries {
+			names = names.Append(PToken{P_STRING, entry.Name()})
+		}
+		globalStack = append(globalStack, PToken{P_STACK, names})
+	},
+	"mkdir": func() {
+		// ( path -- ) creates missing parents too
+		path := globalStack.PopString()
+		if err := os.MkdirAll(interp.Resolve("MKDIR", path), 0o755); err != nil {
+			fs_error("MKDIR", path, err)
+		}
+	},
+	"remove": func() {
+		// ( path -- ) removes a file or an empty directory
+		path := globalStack.PopString()
+		resolved := interp.Resolve("REMOVE", path)
+		if resolved == interp.root("REMOVE") {
+			panicf("[REMOVE] permission denied: cannot remove the root")
+		}
+		if err := os.Remove(resolved); err != nil {
+			fs_error("REMOVE", path, err)
+		}
+	},
+	"glob": func() {
+		// ( pattern -- paths ) paths are relative to the root
+		pattern := globalStack.PopString()
+		root := interp.root("GLOB")
+		full_pattern := interp.Resolve("GLOB", pattern)
+		matches, err := filepath.Glob(full_pattern)
+		if err != nil {
+			panicf("[GLOB] %v: %v", pattern, err)
+		}
+		sort.Strings(matches)
+		var paths IList
+		for _, match := range matches {
+			if real, err := filepath.EvalSymlinks(match); err != nil || !is_within(root, real) {
+				continue
+			}
+			rel, _ := filepath.Rel(root, match)
+			paths = paths.Append(PToken{P_STRING, rel})
+		}
+		globalStack = append(globalStack, PToken{P_STACK, paths})
+	},
+}
+
+// pathBuiltins only work on path strings and never touch the file system
+var pathBuiltins = map[string]func(){
+	"pathjoin": func() {
+		// ( a b -- a/b )
+		second := globalStack.PopString()
+		first := globalStack.PopString()
+		globalStack = append(globalStack, PToken{P_STRING, filepath.Join(first, second)})
+	},
+	"basename": func() {
+		path := globalStack.PopString()
+		globalStack = append(globalStack, PToken{P_STRING, filepath.Base(path)})
+	},
+	"dirname": func() {
+		path := globalStack.PopString()
+		globalStack = append(globalStack, PToken{P_STRING, filepath.Dir(path)})
+	},
+	"ext": func() {
+		path := globalStack.PopString()
+		globalStack = append(globalStack, PToken{P_STRING, filepath.Ext(path)})
+	},
+}
@@ -0,0 +1,89 @@
+package numen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sandbox makes a root and a directory outside it, both real paths
+func sandbox(t *testing.T) (root string, outside string) {
+	t.Helper()
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, outside = filepath.Join(base, "root"), filepath.Join(base, "outside")
+	for _, dir := range []string{root, outside, filepath.Join(root, "dir")} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"dangling":    filepath.Join(outside, "pwned"),
+		"relative":    "../outside/pwned",
+		"outdir":      outside,
+		"dir/up":      "../../outside",
+		"inside":      filepath.Join(root, "dir", "new.txt"),
+		"dir/sibling": "../inside",
+		"loop":        "loop",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("cannot make symlinks: %v", err)
+		}
+	}
+	return root, outside
+}
+
+func TestResolve(t *testing.T) {
+	root, _ := sandbox(t)
+	in := NewInterpreter(WithRoot(root))
+	tests := []struct {
+		path string
+		want string // the resolved path inside root, or an error
+	}{
+		{"file.txt", "file.txt"},
+		{"dir/../file.txt", "file.txt"},
+		{"/etc/passwd", "etc/passwd"},
+		{"inside", "dir/new.txt"},
+		{"dir/sibling", "dir/new.txt"},
+		{"../file.txt", "permission denied"},
+		{"dir/../../file.txt", "permission denied"},
+		{"dangling", "permission denied"},
+		{"relative", "permission denied"},
+		{"outdir/file.txt", "permission denied"},
+		{"dir/up/pwned", "permission denied"},
+		{"loop", "too many levels"},
+	}
+	for _, test := range tests {
+		var got string
+		err := protect(func() { got = in.Resolve("TEST", test.path) })
+		if strings.Contains(test.want, " ") {
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Resolve(%q) = %v, %v, want an error with %q", test.path, got, err, test.want)
+			}
+		} else if err != nil || got != filepath.Join(root, test.want) {
+			t.Errorf("Resolve(%q) = %v, %v, want %v", test.path, got, err, filepath.Join(root, test.want))
+		}
+	}
+}
+
+func TestWriteFileThroughDanglingLink(t *testing.T) {
+	root, outside := sandbox(t)
+	in := NewInterpreter(WithRoot(root))
+	_, err := in.Run("<test>", `"pwned" "dangling" writefile`)
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("writefile through a dangling link gave %v, want permission denied", err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "pwned")); err == nil {
+		t.Errorf("writefile created a file outside the root")
+	}
+	if _, err := in.Run("<test>", `"ok" "inside" writefile`); err != nil {
+		t.Errorf("writefile through a link inside the root: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "dir", "new.txt")); string(data) != "ok" {
+		t.Errorf("the link target holds %q, want %q", data, "ok")
+	}
+}
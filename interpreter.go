@@ -0,0 +1,112 @@
+package numen
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+)
+
+// Interpreter holds the host facing settings of a run. The data stack and
+// scopes are still global, builtins reach these settings through interp.
+type Interpreter struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Root   string // directory the file builtins and import are confined to
+	Strict bool   // unbound symbols are an error instead of being pushed
+
+	// Capabilities allowed to scripts, nil allows every builtin
+	Capabilities map[Capability]bool
+
+	Debugger    *Debugger // stops the script before words, may be nil
+	Tracer      Tracer    // told about every word, may be nil
+	TraceFilter TraceFilter
+	Profiler    *Profiler // times words and blocks, may be nil
+	Coverage    *Coverage // counts the words and branches run, may be nil
+
+	Budget  Budget
+	Context context.Context // stops the script when done, may be nil
+
+	steps int64 // words run so far
+	depth int   // current run_function nesting
+
+	stdin        *bufio.Reader
+	stdin_source io.Reader // the reader stdin was built for
+
+	host_builtins map[string]Builtin // Go words added with WithBuiltin
+
+	modules map[string]loadedModule // imported modules by absolute path
+	loading []string                // files being run, innermost last
+}
+
+// Option configures an Interpreter in NewInterpreter
+type Option func(*Interpreter)
+
+func WithStdin(reader io.Reader) Option {
+	return func(in *Interpreter) {
+		in.Stdin = reader
+	}
+}
+
+func WithStdout(writer io.Writer) Option {
+	return func(in *Interpreter) {
+		in.Stdout = writer
+	}
+}
+
+// WithStrict makes unbound symbols an error, quoted symbols still push
+func WithStrict() Option {
+	return func(in *Interpreter) {
+		in.Strict = true
+	}
+}
+
+func NewInterpreter(options ...Option) *Interpreter {
+	in := &Interpreter{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Root:   ".",
+		Budget: Budget{MaxDepth: DEFAULT_MAX_DEPTH},
+	}
+	for _, option := range options {
+		option(in)
+	}
+	return in
+}
+
+// interp is the interpreter builtins run against
+var interp = NewInterpreter()
+
+// input returns a buffered reader over Stdin, rebuilt when Stdin changes
+func (in *Interpreter) input() *bufio.Reader {
+	if in.stdin == nil || in.stdin_source != in.Stdin {
+		in.stdin = bufio.NewReader(in.Stdin)
+		in.stdin_source = in.Stdin
+	}
+	return in.stdin
+}
+
+// ReadLine reads the next line without its line ending, ok is false at the
+// end of input.
+func (in *Interpreter) ReadLine() (line string, ok bool) {
+	line, err := in.input().ReadString('\n')
+	if err != nil && err != io.EOF {
+		panicf("[READ] %v", err)
+	}
+	if err == io.EOF && line == "" {
+		return "", false
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, true
+}
+
+// ReadAll reads everything left on Stdin
+func (in *Interpreter) ReadAll() string {
+	data, err := io.ReadAll(in.input())
+	if err != nil {
+		panicf("[READ] %v", err)
+	}
+	return string(data)
+}
@@ -0,0 +1,116 @@
+package numen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lineMode configures running a program once per line of stdin, like awk
+type lineMode struct {
+	print     bool   // print the top of the stack after each line
+	split     bool   // also push the fields of each line
+	separator string // field separator, empty splits on whitespace
+}
+
+// program_pos is where a program given on the command line starts
+var program_pos = Position{"<program>", 1, 1}
+
+// split_line_program separates the BEGIN and END blocks from the per line
+// body of a line mode program. The body is the program's own source with
+// those blocks blanked out, so its words and error positions stay as typed.
+func split_line_program(program string) (begin []IBlock, body IBlock, end []IBlock) {
+	words := parse_words(program, program_pos)
+	source := []rune(program)
+	var rest []PWord
+	for ix := 0; ix < len(words); ix++ {
+		word := words[ix]
+		if word.Type == P_SYMBOL && !word.Quoted && Contains(word.Value.(string), "BEGIN", "END") {
+			assert(ix+1 < len(words) && words[ix+1].Type == P_BLOCK, "[LINES] %v must be followed by a block", word.Value)
+			if word.Value == "BEGIN" {
+				begin = append(begin, words[ix+1].Value.(IBlock))
+			} else {
+				end = append(end, words[ix+1].Value.(IBlock))
+			}
+			to := len(source)
+			if ix+2 < len(words) {
+				to = rune_index(source, words[ix+2].Pos)
+			}
+			blank(source[rune_index(source, word.Pos):to])
+			ix++
+			continue
+		}
+		rest = append(rest, word)
+	}
+
+	if len(rest) == 1 && rest[0].Type == P_BLOCK {
+		// numen -n '{ ... }'
+		return begin, rest[0].Value.(IBlock), end
+	}
+	return begin, IBlock{Code: string(source), Pos: program_pos}, end
+}
+
+// rune_index finds pos in the program source, counting lines and columns
+// like the parser does
+func rune_index(source []rune, pos Position) int {
+	line, col := program_pos.Line, program_pos.Col
+	for ix, char := range source {
+		if line == pos.Line && col == pos.Col {
+			return ix
+		}
+		if char == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return len(source)
+}
+
+// blank replaces source with spaces, keeping line breaks
+func blank(source []rune) {
+	for ix, char := range source {
+		if char != '\n' {
+			source[ix] = ' '
+		}
+	}
+}
+
+func (mode lineMode) fields(line string) PToken {
+	var parts []string
+	if mode.separator == "" {
+		parts = strings.Fields(line)
+	} else {
+		parts = strings.Split(line, mode.separator)
+	}
+	var fields IList
+	for _, part := range parts {
+		fields = fields.Append(PToken{P_STRING, part})
+	}
+	return PToken{P_STACK, fields}
+}
+
+// run_lines runs the body once per stdin line with the line pushed. It runs
+// directly in the global scope so stored names persist between lines.
+func run_lines(program string, mode lineMode) {
+	begin, body, end := split_line_program(program)
+	for _, block := range begin {
+		run_function(block.Code, block.Pos, globalScope)
+	}
+	for {
+		line, ok := interp.ReadLine()
+		if !ok {
+			break
+		}
+		globalStack = append(globalStack, PToken{P_STRING, line})
+		if mode.split {
+			globalStack = append(globalStack, mode.fields(line))
+		}
+		run_function(body.Code, body.Pos, globalScope)
+		if mode.print && len(globalStack) > 0 {
+			fmt.Fprintln(interp.Stdout, globalStack.PopAny().Display())
+		}
+	}
+	for _, block := range end {
+		run_function(block.Code, block.Pos, globalScope)
+	}
+}
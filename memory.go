@@ -0,0 +1,113 @@
+package numen
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+)
+
+// IList is the value of a P_STACK token
+type IList = PVector[PToken]
+
+// IMemory is a string keyed memory that remembers the order its keys were
+// first stored in. Memories are persistent, Set returns an updated version
+// and leaves the original untouched.
+type IMemory struct {
+	keys    PVector[string]
+	entries PHashMap[memoryEntry]
+}
+
+type memoryEntry struct {
+	index int // position in keys
+	value PToken
+}
+
+func NewMemory() IMemory {
+	return IMemory{}
+}
+
+func (m IMemory) Len() int {
+	return m.keys.Len()
+}
+
+func (m IMemory) Get(key string) (PToken, bool) {
+	entry, ok := m.entries.Get(key)
+	return entry.value, ok
+}
+
+// Set returns a memory with key bound to value. Storing to an existing key
+// keeps its original position.
+func (m IMemory) Set(key string, value PToken) IMemory {
+	if entry, exists := m.entries.Get(key); exists {
+		return IMemory{keys: m.keys, entries: m.entries.Set(key, memoryEntry{entry.index, value})}
+	}
+	return IMemory{
+		keys:    m.keys.Append(key),
+		entries: m.entries.Set(key, memoryEntry{m.keys.Len(), value}),
+	}
+}
+
+// Keys returns the keys in insertion order
+func (m IMemory) Keys() []string {
+	return m.keys.ToSlice()
+}
+
+// Each calls fn for every entry in insertion order
+func (m IMemory) Each(fn func(key string, value PToken)) {
+	m.keys.Each(func(_ int, key string) {
+		entry, _ := m.entries.Get(key)
+		fn(key, entry.value)
+	})
+}
+
+func (m IMemory) String() (result string) {
+	result = "["
+	m.Each(func(key string, value PToken) {
+		result += " " + key + " " + value.String()
+	})
+	return result + " ]"
+}
+
+// MarshalJSON writes the memory as a JSON object with keys in insertion order
+func (m IMemory) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	var err error
+	m.Each(func(key string, value PToken) {
+		if err != nil {
+			return
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key_json, _ := json.Marshal(key)
+		buf.Write(key_json)
+		buf.WriteByte(':')
+		var value_json []byte
+		value_json, err = json.Marshal(value)
+		buf.Write(value_json)
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON exports the token's value, symbols, blocks and type literals
+// become strings.
+func (token PToken) MarshalJSON() ([]byte, error) {
+	if token.Type == P_FLOAT {
+		fvalue := token.Value.(float64)
+		if math.IsNaN(fvalue) || math.IsInf(fvalue, 0) {
+			return nil, &json.UnsupportedValueError{Str: token.String()}
+		}
+	} else if token.Type == P_STACK {
+		return json.Marshal(append([]PToken{}, token.Value.(IList).ToSlice()...))
+	} else if token.Type == P_TYPE_LITERAL {
+		return json.Marshal(token.Value.(TypeLiterals).String())
+	} else if token.Type == P_BLOCK {
+		return json.Marshal(token.Value.(IBlock).Code)
+	}
+	return json.Marshal(token.Value)
+}
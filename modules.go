@@ -0,0 +1,151 @@
+package numen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// find_module resolves an import path against the importing file's
+// directory first and then every NUMENPATH entry. The .nm extension may be
+// left out. Like the file builtins, imports are confined to Root: absolute
+// paths are taken relative to it and modules are found through Resolve, so
+// a module outside Root is a permission error. It returns the real path.
+func (in *Interpreter) find_module(path string) string {
+	root := in.root("IMPORT")
+	var dirs []string
+	if filepath.IsAbs(path) {
+		dirs = []string{root}
+	} else {
+		if len(in.loading) > 0 {
+			dirs = append(dirs, filepath.Dir(in.loading[len(in.loading)-1]))
+		} else {
+			dirs = append(dirs, ".")
+		}
+		dirs = append(dirs, filepath.SplitList(os.Getenv("NUMENPATH"))...)
+	}
+	candidates := []string{path}
+	if filepath.Ext(path) == "" {
+		candidates = append(candidates, path+".nm")
+	}
+	for _, dir := range dirs {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			panicf("[IMPORT] %v: %v", path, err)
+		}
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = real
+		}
+		for _, candidate := range candidates {
+			rel, err := filepath.Rel(root, filepath.Join(dir, candidate))
+			if err != nil {
+				panicf("[IMPORT] permission denied: %v escapes the root", path)
+			}
+			full := in.Resolve("IMPORT", rel)
+			if info, err := os.Stat(full); err == nil && !info.IsDir() {
+				return full
+			}
+		}
+	}
+	panicf("[IMPORT] module %v not found", path)
+	return ""
+}
+
+// loadedModule is what a module leaves behind, its top level names and
+// the words it defined
+type loadedModule struct {
+	memory IMemory
+	words  map[string][]IBlock
+}
+
+// Import runs a module file once and returns a memory of the names it
+// stored at its top level, in the order they were first stored. Later imports of the same file reuse the result.
+func (in *Interpreter) Import(path string) IMemory {
+	return in.import_module(path).memory
+}
+
+// import_module runs a module with a dictionary of its own, its words only
+// reach the importer through the module name
+func (in *Interpreter) import_module(path string) loadedModule {
+	abs := in.find_module(path)
+	if module, ok := in.modules[abs]; ok {
+		return module
+	}
+	for ix, loading := range in.loading {
+		if loading == abs {
+			chain := append(append([]string(nil), in.loading[ix:]...), abs)
+			for cx := range chain {
+				chain[cx] = filepath.Base(chain[cx])
+			}
+			panicf("[IMPORT] import cycle: %v", strings.Join(chain, " -> "))
+		}
+	}
+	code, err := os.ReadFile(abs)
+	if err != nil {
+		panicf("[IMPORT] %v: %v", path, err)
+	}
+
+	// run the module on its own stack, scope and dictionary
+	saved_stack, saved_scope := globalStack, globalScope
+	globalStack, globalScope = nil, NewScope(nil)
+	globalScope.words = map[string][]IBlock{}
+	in.loading = append(in.loading, abs)
+	defer func() {
+		in.loading = in.loading[:len(in.loading)-1]
+		globalStack, globalScope = saved_stack, saved_scope
+	}()
+	run_function(string(code), Position{abs, 1, 1}, globalScope)
+
+	memory := NewMemory()
+	for _, name := range globalScope.order {
+		memory = memory.Set(name, globalScope.vars[name])
+	}
+	module := loadedModule{memory, globalScope.words}
+	if in.modules == nil {
+		in.modules = map[string]loadedModule{}
+	}
+	in.modules[abs] = module
+	return module
+}
+
+// module_name is the name an imported file is bound to, its base name
+// without the extension.
+func module_name(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// lookup finds a variable from the current scope outwards. Dotted names
+// like geometry.area reach into memories.
+func lookup(name string) (PToken, bool) {
+	if value, ok := currentScope.Lookup(name); ok {
+		return value, true
+	}
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 {
+		return PToken{}, false
+	}
+	value, ok := currentScope.Lookup(parts[0])
+	for _, part := range parts[1:] {
+		if !ok || value.Type != P_MEMORY || part == "" {
+			return PToken{}, false
+		}
+		value, ok = value.Value.(IMemory).Get(part)
+	}
+	return value, ok
+}
+
+var moduleBuiltins = map[string]func(){
+	"import": func() {
+		// Syntax: "lib/geometry.nm" import, binds the module to geometry
+		path := globalStack.PopString()
+		module := interp.import_module(path)
+		name := module_name(path)
+		currentScope.Define(name, PToken{P_MEMORY, module.memory})
+		// words of the module run as geometry.area, with the module's dictionary
+		words := dictionary()
+		for word, definitions := range module.words {
+			words[name+"."+word] = definitions
+		}
+	},
+}
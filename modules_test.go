@@ -0,0 +1,106 @@
+package numen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func write_files(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, code := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(code), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestModuleWords(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"geo.nm": `
+			: double 2 * ;
+			: sq dup * double ;
+			{ 10 double } 'area store
+		`,
+		"dotted.nm":   `"geo" import 4 geo.sq geo.area run`,
+		"bare.nm":     `"geo" import 4 sq`,
+		"helper.nm":   `"geo" import 3 double`,
+		"shadowed.nm": `: double 100 ; "geo" import 1 geo.sq double`,
+	})
+	tests := []struct {
+		file string
+		want IStack
+	}{
+		// module words run with the module's dictionary
+		{"dotted.nm", IStack{integer(32), integer(20)}},
+		// but do not leak into the importer's
+		{"bare.nm", IStack{integer(4), symbol("sq")}},
+		{"helper.nm", IStack{integer(3), symbol("double")}},
+		// and the importer's words do not leak into the module
+		{"shadowed.nm", IStack{integer(2), integer(100)}},
+	}
+	for _, test := range tests {
+		got, err := NewInterpreter(WithRoot(dir)).RunFile(filepath.Join(dir, test.file))
+		if err != nil {
+			t.Errorf("%v: %v", test.file, err)
+			continue
+		}
+		if !list_of(got...).Equal(list_of(test.want...)) {
+			t.Errorf("%v left %v, want %v", test.file, list_of(got...).Repr(), list_of(test.want...).Repr())
+		}
+	}
+}
+
+func TestModuleKeepsStoreOrder(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"order.nm": `3 'zeta store 1 'alpha store 2 'mid store 4 'zeta store`,
+	})
+	module := NewInterpreter(WithRoot(dir)).Import("/order.nm")
+	var keys []string
+	module.Each(func(key string, _ PToken) {
+		keys = append(keys, key)
+	})
+	if strings.Join(keys, " ") != "zeta alpha mid" {
+		t.Errorf("the module memory has the keys %v, want them in store order", keys)
+	}
+}
+
+func TestImportStaysInRoot(t *testing.T) {
+	outside := write_files(t, map[string]string{"secret.nm": `1 'key store`})
+	dir := write_files(t, map[string]string{"lib.nm": `2 'value store`})
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.nm"), filepath.Join(root, "linked.nm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../lib.nm", filepath.Join(root, "up.nm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "inner.nm"), []byte(`3 'inner store`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		code string
+		want string // the error, empty when the import works
+	}{
+		{`"inner" import inner.inner`, ""},
+		{`"/inner.nm" import inner.inner`, ""},
+		{`"../lib" import`, "permission denied"},
+		{`"linked" import`, "permission denied"},
+		{`"up" import`, "permission denied"},
+		{`"` + filepath.Join(outside, "secret.nm") + `" import`, "not found"},
+	}
+	for _, test := range tests {
+		_, err := NewInterpreter(WithRoot(root)).Run(filepath.Join(root, "main.nm"), test.code)
+		if test.want == "" && err != nil {
+			t.Errorf("%v: %v", test.code, err)
+		} else if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("%v: got %v, want an error with %q", test.code, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+const vscode = require('vscode');
+
+// The debug adapter is `numen dap`, found on PATH unless numen.path says
+// where the binary is.
+function activate(context) {
+  context.subscriptions.push(
+    vscode.debug.registerDebugAdapterDescriptorFactory('numen', {
+      createDebugAdapterDescriptor() {
+        const numen = vscode.workspace.getConfiguration('numen').get('path') || 'numen';
+        return new vscode.DebugAdapterExecutable(numen, ['dap']);
+      },
+    }),
+  );
+}
+
+function deactivate() {}
+
+module.exports = { activate, deactivate };
@@ -1,11 +1,15 @@
-package main
+package numen
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"unicode"
 )
 
@@ -21,6 +25,7 @@ const (
 	P_MEMORY // []
 	P_TYPE_LITERAL
 	P_SYMBOL
+	P_EOF // end of input, the parser never produces it
 )
 
 var PTypeName = map[PType]string{
@@ -33,6 +38,7 @@ var PTypeName = map[PType]string{
 	P_MEMORY:       "Memory",
 	P_TYPE_LITERAL: "Type Literal",
 	P_SYMBOL:       "Symbol",
+	P_EOF:          "EOF",
 }
 
 func (tp PType) String() string {
@@ -90,12 +96,12 @@ type PToken struct {
 func (token PToken) String() (result string) {
 	if token.Type == P_STACK {
 		result += fmt.Sprintf("<%v (", token.Type)
-		for ix, tok := range (token.Value).(IStack) {
+		(token.Value).(IList).Each(func(ix int, tok PToken) {
 			if ix > 0 {
 				result += " "
 			}
 			result += fmt.Sprintf("%v", tok)
-		}
+		})
 		result += ")>"
 	} else if token.Type == P_STRING {
 		result = fmt.Sprintf("<%v \"%v\">", token.Type, token.Value)
@@ -105,25 +111,117 @@ func (token PToken) String() (result string) {
 	return result
 }
 
-type IScope map[string]PToken
-
 type loopContext struct {
 	shouldBreak bool
 }
 
+// EOF_MARKER is pushed by the read builtins once the input is exhausted.
+// It has a type of its own so no value a script builds can be mistaken for it.
+var EOF_MARKER = PToken{P_EOF, "EOF"}
+
 var globalStack IStack
-var globalScope = IScope{}
+var globalScope = NewScope(nil)
+
+// currentScope is the innermost scope of the code being run
+var currentScope = globalScope
 var loopStack []*loopContext
+
+// currentPos is the position of the word being run
+var currentPos Position
+
+// reset_globals gives the next program a fresh stack, scope and dictionary
+func reset_globals() {
+	globalStack = nil
+	globalScope = NewScope(nil)
+	currentScope = globalScope
+	loopStack = nil
+	userWords = map[string][]IBlock{}
+	overriding = map[string]int{}
+}
+
 var builtins map[string]func()
 
 func init() {
 	builtins = map[string]func(){
 		"dbgprint": func() {
 			value := globalStack.PopAny()
-			fmt.Printf("<%v, %v>\n", value.Type, value.Value)
+			fmt.Fprintf(interp.Stdout, "<%v, %v>\n", value.Type, value.Value)
 			// Push it back so it doesn't consume the value
 			globalStack = append(globalStack, value)
 		},
+		"print": func() {
+			value := globalStack.PopAny()
+			fmt.Fprint(interp.Stdout, value.Display())
+		},
+		"println": func() {
+			value := globalStack.PopAny()
+			fmt.Fprintln(interp.Stdout, value.Display())
+		},
+		"printf": func() {
+			// Syntax: args... "format" printf
+			fmt.Fprint(interp.Stdout, format_from_stack("PRINTF"))
+		},
+		"format": func() {
+			// Syntax: args... "format" format
+			globalStack = append(globalStack, PToken{P_STRING, format_from_stack("FORMAT")})
+		},
+		"readline": func() {
+			// ( -- line ) or ( -- EOF ) at the end of input
+			line, ok := interp.ReadLine()
+			if !ok {
+				globalStack = append(globalStack, EOF_MARKER)
+				return
+			}
+			globalStack = append(globalStack, PToken{P_STRING, line})
+		},
+		"readall": func() {
+			// ( -- input )
+			globalStack = append(globalStack, PToken{P_STRING, interp.ReadAll()})
+		},
+		"readlines": func() {
+			// ( -- lines )
+			var lines IList
+			for {
+				line, ok := interp.ReadLine()
+				if !ok {
+					break
+				}
+				lines = lines.Append(PToken{P_STRING, line})
+			}
+			globalStack = append(globalStack, PToken{P_STACK, lines})
+		},
+		"readnum": func() {
+			// ( -- number ) or ( -- EOF ) at the end of input
+			line, ok := interp.ReadLine()
+			if !ok {
+				globalStack = append(globalStack, EOF_MARKER)
+				return
+			}
+			line = strings.TrimSpace(line)
+			if ivalue, err := strconv.ParseInt(line, 10, 64); err == nil {
+				globalStack = append(globalStack, PToken{P_INT, ivalue})
+			} else if fvalue, err := strconv.ParseFloat(line, 64); err == nil {
+				globalStack = append(globalStack, PToken{P_FLOAT, fvalue})
+			} else {
+				panicf("[READNUM] %q is not a number", line)
+			}
+		},
+		"eof?": func() {
+			// ( a -- a bool )
+			value := globalStack.PopAny()
+			globalStack = append(globalStack, value)
+			globalStack = append(globalStack, PToken{P_BOOLEAN, value.Type == P_EOF})
+		},
+		"repr": func() {
+			// ( a -- source )
+			value := globalStack.PopAny()
+			globalStack = append(globalStack, PToken{P_STRING, value.Repr()})
+		},
+		"parse": func() {
+			// ( source -- a )
+			source := globalStack.PopString()
+			globalStack = append(globalStack, parse_value(source))
+		},
 		"+": func() {
 			first := globalStack.PopAny()
 			second := globalStack.PopAny()
@@ -249,13 +347,23 @@ func init() {
 			assert(varname_token.Type == P_SYMBOL, "[STORE] variable name must be a symbol, got %v", varname_token.Type)
 			varname := varname_token.Value.(string)
 			value := globalStack.PopAny()
-			globalScope[varname] = value
+			currentScope.Define(varname, value)
+		},
+		"set": func() {
+			// Syntax: value name set, updates an existing binding
+			varname_token := globalStack.PopAny()
+			assert(varname_token.Type == P_SYMBOL, "[SET] variable name must be a symbol, got %v", varname_token.Type)
+			varname := varname_token.Value.(string)
+			value := globalStack.PopAny()
+			if !currentScope.Set(varname, value) {
+				panicf("[SET] variable %v not found", varname)
+			}
 		},
 		"load": func() {
 			varname_token := globalStack.PopAny()
 			assert(varname_token.Type == P_SYMBOL, "[LOAD] variable name must be a symbol, got %v", varname_token.Type)
 			varname := varname_token.Value.(string)
-			value, ok := globalScope[varname]
+			value, ok := lookup(varname)
 			if !ok {
 				panicf("[LOAD] variable %v not found", varname)
 			}
@@ -263,23 +371,21 @@ func init() {
 		},
 		"run": func() {
 			code_block := globalStack.PopBlock()
-			run_function(code_block, nil)
+			run_block(code_block)
 		},
 		"push": func() {
 			// Syntax: value stack push
 			stack := globalStack.PopStack()
 			value := globalStack.PopAny()
-			stack = append(stack, value)
-			globalStack = append(globalStack, PToken{P_STACK, stack})
+			globalStack = append(globalStack, PToken{P_STACK, stack.Append(value)})
 		},
 		"pop": func() {
 			stack := globalStack.PopStack()
-			if len(stack) == 0 {
+			if stack.Len() == 0 {
 				panicf("[POP] cannot pop from empty stack")
 			}
-			value := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-			globalStack = append(globalStack, PToken{P_STACK, stack})
+			value := stack.Last()
+			globalStack = append(globalStack, PToken{P_STACK, stack.Pop()})
 			globalStack = append(globalStack, value)
 		},
 		"swap": func() {
@@ -329,7 +435,7 @@ func init() {
 			if mem_or_sym.Type == P_SYMBOL {
 				// Load from scope: value key memsym storeto
 				varname := mem_or_sym.Value.(string)
-				mem_token, ok := globalScope[varname]
+				mem_token, ok := lookup(varname)
 				if !ok {
 					panicf("[STORETO] variable %v not found", varname)
 				}
@@ -343,13 +449,7 @@ func init() {
 			}
 
 			// Create new memory (immutable)
-			new_memory := make(IMemory)
-			for k, v := range memory {
-				new_memory[k] = v
-			}
-			new_memory[key] = value
-
-			globalStack = append(globalStack, PToken{P_MEMORY, new_memory})
+			globalStack = append(globalStack, PToken{P_MEMORY, memory.Set(key, value)})
 		},
 		"loadfrom": func() {
 			// Pop memory/symbol first (top of stack), then key
@@ -363,7 +463,7 @@ func init() {
 			if mem_or_sym.Type == P_SYMBOL {
 				// Load from scope first: a mymem loadfrom
 				varname := mem_or_sym.Value.(string)
-				mem_token, ok := globalScope[varname]
+				mem_token, ok := lookup(varname)
 				if !ok {
 					panicf("[LOADFROM] variable %v not found", varname)
 				}
@@ -376,7 +476,7 @@ func init() {
 				panicf("[LOADFROM] expected symbol or memory, got %v", mem_or_sym.Type)
 			}
 
-			value, ok := memory[key]
+			value, ok := memory.Get(key)
 			if !ok {
 				panicf("[LOADFROM] key %v not found in memory", key)
 			}
@@ -391,7 +491,7 @@ func init() {
 			if func_or_sym.Type == P_SYMBOL {
 				// Load from scope: sumfunc call
 				varname := func_or_sym.Value.(string)
-				func_token, ok := globalScope[varname]
+				func_token, ok := lookup(varname)
 				if !ok {
 					panicf("[CALL] variable %v not found", varname)
 				}
@@ -405,20 +505,23 @@ func init() {
 			}
 
 			// Check for params (ignore for now)
-			if _, has_params := function["params"]; has_params {
+			if _, has_params := function.Get("params"); has_params {
 				// TODO: handle params later
 			}
 
 			// Get code block
-			code_token, ok := function["code"]
+			code_token, ok := function.Get("code")
 			if !ok {
 				panicf("[CALL] function has no 'code' key")
 			}
 			assert(code_token.Type == P_BLOCK, "[CALL] 'code' must be a block, got %v", code_token.Type)
-			code_block := code_token.Value.(string)
+			code_block := code_token.Value.(IBlock)
 
 			// Run the code
-			run_function(code_block, nil)
+			if interp.Profiler != nil && func_or_sym.Type == P_SYMBOL {
+				interp.Profiler.name_next(func_or_sym.Value.(string))
+			}
+			run_block(code_block)
 		},
 		"<": func() {
 			second := globalStack.PopAny()
@@ -491,46 +594,41 @@ func init() {
 		"==": func() {
 			second := globalStack.PopAny()
 			first := globalStack.PopAny()
-			var result bool
-			if first.Type != second.Type {
-				result = false
-			} else if first.Type == P_INT {
-				result = first.Value.(int64) == second.Value.(int64)
-			} else if first.Type == P_FLOAT {
-				result = first.Value.(float64) == second.Value.(float64)
-			} else if first.Type == P_STRING {
-				result = first.Value.(string) == second.Value.(string)
-			} else if first.Type == P_BOOLEAN {
-				result = first.Value.(bool) == second.Value.(bool)
-			} else {
-				panicf("[==] cannot compare types %v", first.Type)
-			}
-			globalStack = append(globalStack, PToken{P_BOOLEAN, result})
+			globalStack = append(globalStack, PToken{P_BOOLEAN, first.Equal(second)})
 		},
 		"!=": func() {
 			second := globalStack.PopAny()
 			first := globalStack.PopAny()
-			var result bool
-			if first.Type != second.Type {
-				result = true
-			} else if first.Type == P_INT {
-				result = first.Value.(int64) != second.Value.(int64)
-			} else if first.Type == P_FLOAT {
-				result = first.Value.(float64) != second.Value.(float64)
-			} else if first.Type == P_STRING {
-				result = first.Value.(string) != second.Value.(string)
-			} else if first.Type == P_BOOLEAN {
-				result = first.Value.(bool) != second.Value.(bool)
-			} else {
-				panicf("[!=] cannot compare types %v", first.Type)
-			}
-			globalStack = append(globalStack, PToken{P_BOOLEAN, result})
+			globalStack = append(globalStack, PToken{P_BOOLEAN, !first.Equal(second)})
+		},
+		"num==": func() {
+			// like == but 1 and 1.0 are equal
+			second := globalStack.PopAny()
+			first := globalStack.PopAny()
+			globalStack = append(globalStack, PToken{P_BOOLEAN, first.NumEqual(second)})
+		},
+		"num!=": func() {
+			second := globalStack.PopAny()
+			first := globalStack.PopAny()
+			globalStack = append(globalStack, PToken{P_BOOLEAN, !first.NumEqual(second)})
+		},
+		"hash": func() {
+			// ( a -- hash )
+			value := globalStack.PopAny()
+			globalStack = append(globalStack, PToken{P_INT, int64(value.Hash())})
 		},
 		"if": func() {
 			block := globalStack.PopBlock()
 			condition := globalStack.PopBoolean()
+			if interp.Coverage != nil {
+				if condition {
+					interp.Coverage.branch(currentPos, 2, 0)
+				} else {
+					interp.Coverage.branch(currentPos, 2, 1)
+				}
+			}
 			if condition {
-				run_function(block, nil)
+				run_block(block)
 			}
 		},
 		"loop": func() {
@@ -544,13 +642,20 @@ func init() {
 			}()
 
 			// Infinite loop until break
-			for {
-				run_function(block, nil)
-
-				// Check if break was called
-				if ctx.shouldBreak {
-					break
+			loop_pos := currentPos
+			for !ctx.shouldBreak {
+				if interp.Coverage != nil {
+					interp.Coverage.branch(loop_pos, 1, 0)
 				}
+				func() {
+					defer func() {
+						// break unwinds the rest of the body
+						if r := recover(); r != nil && !(r == "BREAK" && ctx.shouldBreak) {
+							panic(r)
+						}
+					}()
+					run_block(block)
+				}()
 			}
 		},
 		"break": func() {
@@ -559,18 +664,18 @@ func init() {
 			}
 			// Set flag on innermost loop
 			loopStack[len(loopStack)-1].shouldBreak = true
-			// Panic to exit current iteration
+			// Panic to exit current iteration, the loop recovers it
 			panic("BREAK")
 		},
 		"len": func() {
 			item := globalStack.PopAny()
 			var length int64
 			if item.Type == P_STACK {
-				stack := item.Value.(IStack)
-				length = int64(len(stack))
+				stack := item.Value.(IList)
+				length = int64(stack.Len())
 			} else if item.Type == P_MEMORY {
 				memory := item.Value.(IMemory)
-				length = int64(len(memory))
+				length = int64(memory.Len())
 			} else if item.Type == P_STRING {
 				str := item.Value.(string)
 				length = int64(len(str))
@@ -581,6 +686,24 @@ func init() {
 			globalStack = append(globalStack, item)
 			globalStack = append(globalStack, PToken{P_INT, length})
 		},
+		"keys": func() {
+			// ( mem -- keys ) keys in insertion order
+			memory := globalStack.PopMemory()
+			var keys IList
+			for _, key := range memory.Keys() {
+				keys = keys.Append(PToken{P_SYMBOL, key})
+			}
+			globalStack = append(globalStack, PToken{P_STACK, keys})
+		},
+		"tojson": func() {
+			// ( value -- json )
+			value := globalStack.PopAny()
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				panicf("[TOJSON] %v", err)
+			}
+			globalStack = append(globalStack, PToken{P_STRING, string(encoded)})
+		},
 		"runfrom": func() {
 			// Syntax: mem codeblock runfrom
 			code_block_token := globalStack.PopBlock()
@@ -590,7 +713,7 @@ func init() {
 			if mem_or_sym.Type == P_SYMBOL {
 				// Load from scope: mymem { ... } runfrom
 				varname := mem_or_sym.Value.(string)
-				mem_token, ok := globalScope[varname]
+				mem_token, ok := lookup(varname)
 				if !ok {
 					panicf("[RUNFROM] variable %v not found", varname)
 				}
@@ -603,19 +726,51 @@ func init() {
 				panicf("[RUNFROM] expected symbol or memory, got %v", mem_or_sym.Type)
 			}
 
-			// Convert IMemory to IScope (both are map[string]PToken)
-			local_memory := IScope(memory)
+			// Convert IMemory to IScope
+			local_memory := NewScope(code_block_token.env())
+			memory.Each(func(key string, value PToken) {
+				local_memory.Define(key, value)
+			})
 
 			// Run code with local memory
-			run_function(code_block_token, &local_memory)
+			run_function(code_block_token.Code, code_block_token.Pos, local_memory)
 		},
 	}
+	for name := range builtins {
+		builtinCapability[name] = Core
+	}
+	for capability, names := range capabilityGroups {
+		for _, name := range names {
+			builtinCapability[name] = capability
+		}
+	}
+	register(FS, fsBuiltins)
+	register(Strings, pathBuiltins)
+	register(Modules, moduleBuiltins)
+	register(Core, wordBuiltins)
+	register(Core, errorBuiltins)
+	register(Core, testBuiltins)
+	register(Debug, debugBuiltins)
+}
+
+// PWord is a token as it was read from the source
+type PWord struct {
+	PToken
+	Quoted bool // a 'x or :x symbol that must not be resolved
+	Pos    Position
 }
 
-func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_code_collect *IStack) {
-	defer wg.Done()
+// parser reads code that starts at position start and sends every token to
+// interp_chan, which it closes when done.
+func parser(code string, start Position, interp_chan chan PWord, parsed_code_collect *IStack) {
+	defer close(interp_chan)
 	var current_state = PS_PARSING
 	var word []rune
+	// where the current word starts and, for literals, where their inside starts
+	var line, col = start.Line, start.Col
+	var word_pos Position
+	var content_pos Position
+	var content_started = false
 	// how deep is the parser
 	var block_deepness = 0
 	var stack_deepness = 0
@@ -629,13 +784,22 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 	var closing_star = false        // closing * of */
 	// string escapes
 	var first_string_escape = false // first \
+	// a '"name" symbol, the string is its name
+	var quoted_string = false
+	// strings inside nested literals
+	var nested_string = false
+	var nested_escape = false
 	reset_all := func() {
 		has_digit = false
 		has_dot = false
 		has_letter = false
 		first_comment_slash = false
 		first_string_escape = false
+		quoted_string = false
+		nested_string = false
+		nested_escape = false
 		closing_star = false
+		content_started = false
 		word = []rune{}
 		current_state = PS_PARSING
 		block_deepness = 0
@@ -647,11 +811,18 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 			Value: token_value,
 			Type:  token_type,
 		}
-		interp_chan <- ptoken
+		interp_chan <- PWord{PToken: ptoken, Pos: word_pos}
 		if parsed_code_collect != nil {
 			*parsed_code_collect = append(*parsed_code_collect, ptoken)
 		}
 	}
+	push_quoted := func(name string) {
+		quoted := PToken{P_SYMBOL, name}
+		interp_chan <- PWord{PToken: quoted, Quoted: true, Pos: word_pos}
+		if parsed_code_collect != nil {
+			*parsed_code_collect = append(*parsed_code_collect, quoted)
+		}
+	}
 	append_fast := func(char rune) {
 		if unicode.IsSpace(char) {
 			return
@@ -666,10 +837,35 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 		word = append(word, char)
 	}
 
+	// strings inside blocks, stacks and memories may hold brackets that must
+	// not change the depth, returns true while char belongs to such a string
+	track_nested_string := func(char rune) bool {
+		if nested_string {
+			if nested_escape {
+				nested_escape = false
+			} else if char == '\\' {
+				nested_escape = true
+			} else if char == '"' {
+				nested_string = false
+			}
+			return true
+		} else if char == '"' {
+			nested_string = true
+			return true
+		}
+		return false
+	}
+
 	parse_word := func() {
 		var token_value any
 		var token_type PType
 		var parse_err error
+		if len(word) > 1 && (word[0] == '\'' || word[0] == ':') {
+			// 'x and :x push the symbol itself
+			push_quoted(string(word[1:]))
+			reset_all()
+			return
+		}
 		if has_digit && !has_letter {
 			if has_dot {
 				token_value, parse_err = strconv.ParseFloat(string(word), 64)
@@ -710,6 +906,12 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 			} else if string(word) == "stack" || string(word) == "STACK" {
 				token_value = TL_STACK
 				token_type = P_TYPE_LITERAL
+			} else if string(word) == "memory" || string(word) == "MEMORY" {
+				token_value = TL_MEMORY
+				token_type = P_TYPE_LITERAL
+			} else if string(word) == "symbol" || string(word) == "SYMBOL" {
+				token_value = TL_SYMBOL
+				token_type = P_TYPE_LITERAL
 				// todo: type literal could also be a type?
 			} else { // todo: only accept a limited set
 				token_value = string(word)
@@ -726,8 +928,17 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 	}
 
 	for ix, char := range code {
+		pos := Position{start.File, line, col}
+		if char == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
 		if current_state == PS_PARSING {
 			var end_of_code = false
+			if len(word) == 0 {
+				word_pos = pos
+			}
 			if ix == len(code)-1 {
 				append_fast(char)
 				end_of_code = true
@@ -752,21 +963,31 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 				}
 				current_state = PS_BLOCK
 				block_deepness = 1
+				word_pos = pos
 			} else if char == '(' {
 				if len(word) > 0 {
 					parse_word()
 				}
 				current_state = PS_PROCEDURE
 				stack_deepness = 1
+				word_pos, content_pos = pos, Position{start.File, line, col}
 			} else if char == '[' {
 				if len(word) > 0 {
 					parse_word()
 				}
 				current_state = PS_MEMORY
 				memory_deepness = 1
+				word_pos, content_pos = pos, Position{start.File, line, col}
 			} else if char == '"' {
-				if len(word) > 0 {
-					parse_word()
+				if string(word) == "'" {
+					// '"a b" quotes a name the parser would split
+					word = []rune{}
+					quoted_string = true
+				} else {
+					if len(word) > 0 {
+						parse_word()
+					}
+					word_pos = pos
 				}
 				current_state = PS_STRING
 			} else if char == '/' {
@@ -801,23 +1022,28 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 					first_string_escape = false
 					word[last_index] = '\t'
 					continue
+				} else if char == 'r' {
+					first_string_escape = false
+					word[last_index] = '\r'
+					continue
 				} else if char == '\\' {
 					first_string_escape = false
 					continue
 				}
-				// probably invalid do nothing
+				// probably invalid, keep the backslash as is
+				first_string_escape = false
 			}
 
 			if char == '"' {
-				if len(word) > 0 && word[len(word)-1] == '\\' {
-					word[len(word)-1] = char // char = '"'
+				// build a token
+				// send token
+				if quoted_string {
+					push_quoted(string(word))
 				} else {
-					// build a token
-					// send token
 					push_value(string(word), P_STRING)
-					// clear word
-					reset_all()
 				}
+				// clear word
+				reset_all()
 			} else {
 				if char == '\\' {
 					first_string_escape = true
@@ -825,15 +1051,17 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 				word = append(word, char)
 			}
 		} else if current_state == PS_PROCEDURE {
-			if char == ')' {
+			if track_nested_string(char) {
+				word = append(word, char)
+			} else if char == ')' {
 				if stack_deepness > 1 {
 					stack_deepness -= 1
 					word = append(word, char)
 				} else {
 					// build a token
 					// send token
-					parsed := parser_collect(string(word))
-					push_value(parsed, P_STACK)
+					parsed := parser_collect(string(word), content_pos)
+					push_value(NewVector(parsed...), P_STACK)
 					// clear word
 					reset_all()
 				}
@@ -844,7 +1072,14 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 				word = append(word, char)
 			}
 		} else if current_state == PS_BLOCK {
-			if char == '}' {
+			if !content_started && !unicode.IsSpace(char) {
+				// the block's code starts at its first non space character
+				content_started = true
+				content_pos = pos
+			}
+			if track_nested_string(char) {
+				word = append(word, char)
+			} else if char == '}' {
 				if block_deepness > 1 {
 					block_deepness -= 1
 					word = append(word, char)
@@ -852,7 +1087,10 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 					// build a token
 					// send token
 					cblock := strings.TrimSpace(string(word))
-					push_value(string(cblock), P_BLOCK)
+					if !content_started {
+						content_pos = pos
+					}
+					push_value(IBlock{Code: cblock, Pos: content_pos}, P_BLOCK)
 					// clear word
 					reset_all()
 				}
@@ -863,16 +1101,16 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 				word = append(word, char)
 			}
 		} else if current_state == PS_MEMORY {
-			if char == ']' {
+			if track_nested_string(char) {
+				word = append(word, char)
+			} else if char == ']' {
 				if memory_deepness > 1 {
 					memory_deepness -= 1
 					word = append(word, char)
 				} else {
 					// build a token
 					// send token
-					// For now, only support empty memory []
-					empty_memory := make(IMemory)
-					push_value(empty_memory, P_MEMORY)
+					push_value(parse_memory(string(word), content_pos), P_MEMORY)
 					// clear word
 					reset_all()
 				}
@@ -886,90 +1124,366 @@ func parser(code string, interp_chan chan PToken, wg *sync.WaitGroup, parsed_cod
 	}
 	if !Contains(current_state, PS_PARSING, PS_LINE_COMMENT) {
 		if block_deepness > 0 {
-			panic("[PRSR]: Block never closed, might be a missing '}'")
+			panic(syntax_error(word_pos, "[PRSR]: Block never closed, might be a missing '}'"))
 		} else if stack_deepness > 0 {
-			panic("[PRSR]: Stack never closed, might be a missing ')'")
+			panic(syntax_error(word_pos, "[PRSR]: Stack never closed, might be a missing ')'"))
 		} else if memory_deepness > 0 {
-			panic("[PRSR]: Memory never closed, might be a missing ']'")
+			panic(syntax_error(word_pos, "[PRSR]: Memory never closed, might be a missing ']'"))
 		} else if current_state == PS_STRING {
-			panic("[PRSR]: String never closed, might be a missing '\"'")
+			panic(syntax_error(word_pos, "[PRSR]: String never closed, might be a missing '\"'"))
 		} else {
-			panic("[PRSR]: TODO Parsing Error")
+			panic(syntax_error(word_pos, "[PRSR]: TODO Parsing Error"))
 		}
 	}
-	close(interp_chan)
+}
+
+// parse_memory builds a memory from the inside of a [ key value ... ] literal
+func parse_memory(code string, start Position) IMemory {
+	parsed := parser_collect(code, start)
+	if len(parsed)%2 != 0 {
+		panic("[PRSR]: Memory literal needs key value pairs")
+	}
+	memory := NewMemory()
+	for ix := 0; ix < len(parsed); ix += 2 {
+		key := parsed[ix]
+		if key.Type != P_SYMBOL {
+			panicf("[PRSR]: Memory key must be a symbol, got %v", key.Type)
+		}
+		memory = memory.Set(key.Value.(string), parsed[ix+1])
+	}
+	return memory
+}
+
+// parse_async runs the parser in its own goroutine. wait blocks until the
+// parser is done and returns its error, if it failed.
+func parse_async(code string, start Position) (interp_chan chan PWord, wait func() *NumenError) {
+	interp_chan = make(chan PWord)
+	done := make(chan *NumenError, 1)
+	go func() {
+		defer func() {
+			done <- as_syntax_error(recover(), start)
+		}()
+		parser(code, start, interp_chan, nil)
+	}()
+	return interp_chan, func() *NumenError {
+		return <-done
+	}
 }
 
 // returns all the parsed values instead
-func parser_collect(code string) (parsed_tokens IStack) {
-	interp_chan := make(chan PToken)
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go parser(code, interp_chan, &wg, nil)
+func parser_collect(code string, start Position) (parsed_tokens IStack) {
+	interp_chan, wait := parse_async(code, start)
 	for token := range interp_chan {
-		parsed_tokens = append(parsed_tokens, token)
+		parsed_tokens = append(parsed_tokens, token.PToken)
+	}
+	if err := wait(); err != nil {
+		panic(err)
 	}
-	wg.Wait()
 	return parsed_tokens
 }
 
-func interpret(interp_chan chan PToken, wg *sync.WaitGroup, local_memory *IScope) {
-	defer wg.Done()
-
-	// Catch break panics
+func interpret(interp_chan chan PWord, scope *IScope) {
 	defer func() {
 		if r := recover(); r != nil {
-			if r == "BREAK" {
-				// Drain remaining tokens so parser can finish
-				for range interp_chan {
-				}
-				return // Clean exit for break
+			// Drain remaining tokens so parser can finish
+			for range interp_chan {
 			}
-			panic(r) // Re-throw other panics
+			// errors remember the word they happened at, break passes as is
+			panic(as_error(r))
 		}
 	}()
 
-	for token := range interp_chan {
-		if token.Type == P_SYMBOL {
-			symbol_name := token.Value.(string)
-
-			// 1. Check if it's a builtin operation
-			if builtin, ok := builtins[symbol_name]; ok {
-				builtin()
-				continue
-				// 2. Check local memory (if exists)
-			} else if local_memory != nil {
-				if val, ok := (*local_memory)[symbol_name]; ok {
-					globalStack = append(globalStack, val)
-					continue
-				}
-			}
-			// 3. Unknown symbol - push to stack
-			globalStack = append(globalStack, token)
+	for word := range interp_chan {
+		currentPos = word.Pos
+		if interp.Debugger != nil {
+			interp.Debugger.before(word)
+		}
+		if interp.Coverage != nil {
+			interp.Coverage.hit(word.Pos)
+		}
+		interp.step()
+		if interp.Tracer != nil {
+			trace_word(word, interp_chan, scope)
 		} else {
-			// Push literals to stack
-			globalStack = append(globalStack, token)
+			run_word_token(word, interp_chan, scope)
 		}
+		interp.check_stack()
 	}
 }
 
-func run_function(code_block string, local_memory *IScope) {
-	interp_chan := make(chan PToken)
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go parser(code_block, interp_chan, &wg, nil)
-	go interpret(interp_chan, &wg, local_memory)
-	wg.Wait()
+// run_word_token runs a single word read by the parser
+func run_word_token(word PWord, interp_chan chan PWord, scope *IScope) {
+	token := word.PToken
+	if token.Type == P_SYMBOL && !word.Quoted {
+		symbol_name := token.Value.(string)
+
+		// 1. Word definitions: : name ... ;
+		if symbol_name == ":" {
+			read_definition(interp_chan, scope)
+			return
+		}
+		// 2. Check user words, they shadow variables of the same name
+		if block, ok := find_word(symbol_name); ok {
+			run_word(symbol_name, block)
+			return
+		}
+		// 3. Go words of the host, they can stub out builtins
+		if builtin, ok := interp.host_builtins[symbol_name]; ok {
+			builtin(&globalStack)
+			return
+		}
+		// 4. Check if it's a builtin operation
+		if builtin, ok := builtins[symbol_name]; ok {
+			interp.allow(symbol_name)
+			if interp.Profiler != nil {
+				defer interp.Profiler.begin_builtin(symbol_name)()
+			}
+			builtin()
+			return
+		}
+		// 5. Check every visible scope, geometry.area reaches into memories
+		if val, ok := lookup(symbol_name); ok {
+			if interp.Profiler != nil {
+				interp.Profiler.name_value(symbol_name, val)
+			}
+			globalStack = append(globalStack, val)
+			return
+		}
+		// 6. Unknown symbol - push to stack
+		if interp.Strict {
+			panicf("[STRICT] unbound symbol %v, quote it as '%v to push the symbol", symbol_name, symbol_name)
+		}
+		globalStack = append(globalStack, token)
+	} else {
+		// Push literals to stack, blocks capture the scope they are made in
+		token, _ = capture(token, scope)
+		globalStack = append(globalStack, token)
+	}
+}
+
+// run_function runs code that starts at pos with scope as the innermost
+// scope. The parser streams tokens from its own goroutine while the code
+// runs on the caller's, so errors reach the caller as ordinary panics.
+func run_function(code_block string, pos Position, scope *IScope) {
+	defer interp.enter()()
+	saved_scope, saved_pos := currentScope, currentPos
+	currentScope = scope
+	defer func() {
+		currentScope, currentPos = saved_scope, saved_pos
+	}()
+	if interp.Debugger != nil {
+		defer interp.Debugger.enter_frame(pos, scope)()
+	}
+	if interp.Profiler != nil {
+		defer interp.Profiler.begin_block(pos)()
+	}
+	if interp.Coverage != nil {
+		interp.Coverage.enter(pos)
+	}
+
+	interp_chan, wait := parse_async(code_block, pos)
+	interpret(interp_chan, scope)
+	if err := wait(); err != nil {
+		panic(err)
+	}
 }
 
-func main() {
-	code_raw, _ := os.ReadFile("./test.nm")
+// subcommands are the commands main accepts before the flags
+var subcommands = []string{"debug", "dap", "profile", "cover", "test", "check", "vet"}
+
+// Main runs the numen command line with os.Args
+func Main() {
+	command := "run"
+	if len(os.Args) > 1 && Contains(os.Args[1], subcommands...) {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	}
+
+	line_flag := flag.Bool("n", false, "run the program once for every stdin line, the line is pushed first")
+	print_flag := flag.Bool("p", false, "like -n, and print the top of the stack after every line")
+	split_flag := flag.Bool("a", false, "with -n, also push a stack of the whitespace separated fields")
+	separator := flag.String("F", "", "with -n, also push a stack of the fields split on `separator`")
+	flag.StringVar(&interp.Root, "root", ".", "file builtins and import can only reach paths inside `dir`")
+	flag.BoolVar(&interp.Strict, "strict", false, "fail on unbound symbols instead of pushing them")
+	flag.Int64Var(&interp.Budget.MaxSteps, "max-steps", 0, "stop after running `n` words, 0 for no limit")
+	flag.IntVar(&interp.Budget.MaxStack, "max-stack", 0, "limit the stack to `n` items, 0 for no limit")
+	flag.IntVar(&interp.Budget.MaxDepth, "max-depth", DEFAULT_MAX_DEPTH, "limit nested block calls to `n`, 0 for no limit")
+	flag.Int64Var(&interp.Budget.MaxMemory, "max-memory", 0, "limit values held to about `bytes`, 0 for no limit")
+	allow := flag.String("allow", "all", "comma separated `capabilities` scripts may use: "+capability_list())
+	trace := flag.Bool("trace", false, "log every word with the stack before and after it to stderr")
+	trace_format := flag.String("trace-format", "text", "trace output `format`, text or json")
+	trace_words := flag.String("trace-words", "", "only trace these comma separated `words`")
+	trace_depth := flag.Int("trace-depth", 0, "only trace words at most `n` blocks deep, 0 for all")
+	pprof_path := flag.String("pprof", "", "with profile, also write a pprof profile to `file`")
+	html_path := flag.String("cover-html", "", "with cover, also write an annotated HTML report to `file`")
+	lcov_path := flag.String("lcov", "", "with cover, also write an LCOV tracefile to `file`")
+	run_pattern := flag.String("run", "", "with test, only run tests whose name matches `regexp`")
+	verbose := flag.Bool("v", false, "with test, also list passing tests and their output")
+	update := flag.Bool("update", false, "with test, rewrite the golden .out files")
+	check_types := flag.Bool("types", false, "with check, also check the types of values")
+	timeout := flag.Duration("timeout", 0, "stop the script after `duration`, 0 for no limit")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: numen [file.nm]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       numen debug file.nm")
+		fmt.Fprintln(flag.CommandLine.Output(), "       numen dap")
+		fmt.Fprintln(flag.CommandLine.Output(), "       numen profile [-pprof out.pprof] file.nm")
+		fmt.Fprintln(flag.CommandLine.Output(), "       numen cover [-cover-html out.html] [-lcov out.info] file.nm|dir...")
+		fmt.Fprintln(flag.CommandLine.Output(), "       numen test [-run regexp] [-v] [-update] [file_test.nm|dir...]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       numen check [-types] file.nm|dir...")
+		fmt.Fprintln(flag.CommandLine.Output(), "       numen vet file.nm|dir...")
+		fmt.Fprintln(flag.CommandLine.Output(), "       numen -n|-p [-a] [-F sep] 'program'")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *allow != "all" {
+		capabilities, err := ParseCapabilities(*allow)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		WithCapabilities(capabilities...)(interp)
+	}
+
+	if *trace {
+		if *trace_format == "json" {
+			interp.Tracer = JSONTracer{os.Stderr}
+		} else if *trace_format == "text" {
+			interp.Tracer = TextTracer{os.Stderr}
+		} else {
+			fmt.Fprintf(os.Stderr, "unknown trace format %q\n", *trace_format)
+			os.Exit(2)
+		}
+		interp.TraceFilter.MaxDepth = *trace_depth
+		if *trace_words != "" {
+			interp.TraceFilter.Words = strings.Split(*trace_words, ",")
+		}
+	}
+
+	if *timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		interp.Context = ctx
+	}
+
+	if command == "dap" {
+		// stdin and stdout carry the protocol, the script gets neither
+		server := NewDAPServer(os.Stdin, os.Stdout)
+		interp.Stdin = strings.NewReader("")
+		interp.Stdout = server
+		WithDebugger(NewDebugger(server))(interp)
+		if exit_code := server.Serve(interp.Debugger); exit_code != 0 {
+			os.Exit(exit_code)
+		}
+		return
+	}
+
+	if command == "cover" {
+		if flag.NArg() == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(run_cover(flag.Args(), *html_path, *lcov_path))
+	}
+
+	if command == "check" {
+		if flag.NArg() == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(run_check(flag.Args(), CheckOptions{Types: *check_types}))
+	}
+
+	if command == "vet" {
+		if flag.NArg() == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(run_vet(flag.Args()))
+	}
+
+	if command == "test" {
+		os.Exit(run_test_command(flag.Args(), *run_pattern, *verbose, *update))
+	}
+
+	if *line_flag || *print_flag {
+		if flag.NArg() != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		defer exit_on_error()
+		run_lines(flag.Arg(0), lineMode{
+			print:     *print_flag,
+			split:     *split_flag || *separator != "",
+			separator: *separator,
+		})
+		return
+	}
+
+	path := "./test.nm"
+	if flag.NArg() > 0 {
+		path = flag.Arg(0)
+	}
+	code_raw, _ := os.ReadFile(path)
 	code := string(code_raw)
+	if abs, err := filepath.Abs(path); err == nil {
+		interp.loading = append(interp.loading, abs)
+	}
+
+	if command == "debug" {
+		// debugger commands and script input share stdin
+		WithDebugger(NewDebugger(NewConsoleDebugger(interp.input(), os.Stderr)))(interp)
+	}
 
-	interp_chan := make(chan PToken)
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go parser(code, interp_chan, &wg, nil)
-	go interpret(interp_chan, &wg, nil)
-	wg.Wait()
+	defer exit_on_error()
+	if command == "profile" {
+		WithProfiler(NewProfiler())(interp)
+		// runs before exit_on_error, also when the script fails
+		defer write_profile(interp.Profiler, *pprof_path)
+	}
+	run_function(code, Position{path, 1, 1}, globalScope)
+}
+
+// run_test_command runs numen test and returns the exit code
+func run_test_command(paths []string, run_pattern string, verbose bool, update bool) int {
+	options := TestOptions{Verbose: verbose, Update: update, Out: os.Stdout}
+	if run_pattern != "" {
+		pattern, err := regexp.Compile(run_pattern)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		options.Run = pattern
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := find_test_files(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !run_tests(files, options) {
+		return 1
+	}
+	return 0
+}
+
+// write_profile prints the profile table to stderr and writes the pprof
+// profile when a path is given
+func write_profile(profiler *Profiler, pprof_path string) {
+	profiler.WriteText(os.Stderr)
+	if pprof_path == "" {
+		return
+	}
+	file, err := os.Create(pprof_path)
+	if err == nil {
+		err = profiler.WritePprof(file)
+		if close_err := file.Close(); err == nil {
+			err = close_err
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
 }
@@ -0,0 +1,263 @@
+// Package numentest runs Numen scripts and test functions from Go tests.
+//
+// Each .nm script or test function becomes a t.Run subtest. Scripts can get
+// stack inputs, stdin and stubbed builtins, their output is captured and
+// the final stack is compared with readable diffs.
+//
+// The interpreter keeps its stack and dictionary in globals, so subtests
+// must not call t.Parallel.
+package numentest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"dorukyilmaz.net/numen"
+)
+
+// Script is a Numen program to run
+type Script struct {
+	Path    string                   // file to run
+	Code    string                   // source to run when Path is empty
+	Stack   []any                    // pushed before the script runs, bottom first, see numen.Value
+	Stdin   string                   // what read words read
+	Stubs   map[string]numen.Builtin // Go words replacing builtins of the same name
+	Options []numen.Option           // more interpreter options, such as capabilities
+}
+
+// Result is what a script left behind
+type Result struct {
+	Stack  numen.IStack
+	Output string // what the script printed
+	Err    error  // the Numen error the script stopped with
+
+	t testing.TB
+}
+
+// interpreter makes the interpreter for script, printing into output
+func interpreter(script Script, output *bytes.Buffer) *numen.Interpreter {
+	options := []numen.Option{
+		numen.WithStdin(strings.NewReader(script.Stdin)),
+		numen.WithStdout(output),
+	}
+	for name, builtin := range script.Stubs {
+		options = append(options, numen.WithBuiltin(name, builtin))
+	}
+	return numen.NewInterpreter(append(options, script.Options...)...)
+}
+
+// Run runs script, errors of the script are kept in the result for the
+// Expect methods to report
+func Run(t testing.TB, script Script) *Result {
+	t.Helper()
+	var output bytes.Buffer
+	in := interpreter(script, &output)
+	stack := make([]numen.PToken, len(script.Stack))
+	for ix, value := range script.Stack {
+		stack[ix] = numen.Value(value)
+	}
+	var final numen.IStack
+	var err error
+	if script.Path != "" {
+		final, err = in.RunFile(script.Path, stack...)
+	} else {
+		final, err = in.Run("<script>", script.Code, stack...)
+	}
+	return &Result{Stack: final, Output: output.String(), Err: err, t: t}
+}
+
+// ExpectNoError fails the test if the script stopped with an error
+func (result *Result) ExpectNoError() *Result {
+	result.t.Helper()
+	if result.Err != nil {
+		result.t.Errorf("script failed: %v%v", result.Err, output_note(result.Output))
+	}
+	return result
+}
+
+// ExpectError fails the test unless the script stopped with an error
+// containing text
+func (result *Result) ExpectError(text string) *Result {
+	result.t.Helper()
+	if result.Err == nil {
+		result.t.Errorf("script ran without an error, expected one containing %q", text)
+	} else if !strings.Contains(result.Err.Error(), text) {
+		result.t.Errorf("script failed with %v\nexpected an error containing %q", result.Err, text)
+	}
+	return result
+}
+
+// ExpectStack compares the final stack, bottom first, with values
+// converted by numen.Value. Integers and floats are different values.
+func (result *Result) ExpectStack(values ...any) *Result {
+	result.t.Helper()
+	want := make(numen.IStack, len(values))
+	for ix, value := range values {
+		want[ix] = numen.Value(value)
+	}
+	if diff := StackDiff(want, result.Stack); diff != "" {
+		result.t.Errorf("final stack differs:\n%v%v", diff, error_note(result.Err))
+	}
+	return result
+}
+
+// ExpectStackCode is ExpectStack with the values written as Numen literals,
+// such as `1 "two" ( 3 )`
+func (result *Result) ExpectStackCode(code string) *Result {
+	result.t.Helper()
+	want, err := numen.ParseValues(code)
+	if err != nil {
+		result.t.Fatalf("cannot parse expected stack: %v", err)
+	}
+	if diff := StackDiff(want, result.Stack); diff != "" {
+		result.t.Errorf("final stack differs:\n%v%v", diff, error_note(result.Err))
+	}
+	return result
+}
+
+// ExpectOutput compares what the script printed with want
+func (result *Result) ExpectOutput(want string) *Result {
+	result.t.Helper()
+	if diff := OutputDiff(want, result.Output); diff != "" {
+		result.t.Errorf("output differs:\n%v%v", diff, error_note(result.Err))
+	}
+	return result
+}
+
+// StackDiff describes how got differs from want item by item, it is empty
+// when they are equal
+func StackDiff(want numen.IStack, got numen.IStack) string {
+	var builder strings.Builder
+	for ix := 0; ix < max(len(want), len(got)); ix++ {
+		switch {
+		case ix >= len(got):
+			fmt.Fprintf(&builder, "  [%v] want %v, missing\n", ix, show(want[ix]))
+		case ix >= len(want):
+			fmt.Fprintf(&builder, "  [%v] unexpected %v\n", ix, show(got[ix]))
+		case !want[ix].Equal(got[ix]):
+			fmt.Fprintf(&builder, "  [%v] want %v, got %v\n", ix, show(want[ix]), show(got[ix]))
+		}
+	}
+	if builder.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("  want %v\n  got  %v\n%v", show_stack(want), show_stack(got), builder.String())
+}
+
+// OutputDiff shows the first line where got differs from want, it is
+// empty when they are equal
+func OutputDiff(want string, got string) string {
+	if want == got {
+		return ""
+	}
+	want_lines := strings.Split(want, "\n")
+	got_lines := strings.Split(got, "\n")
+	for ix := 0; ; ix++ {
+		want_line, got_line := "end of output", "end of output"
+		if ix < len(want_lines) {
+			want_line = strconv.Quote(want_lines[ix])
+		}
+		if ix < len(got_lines) {
+			got_line = strconv.Quote(got_lines[ix])
+		}
+		if want_line != got_line {
+			return fmt.Sprintf("  line %v:\n  want %v\n  got  %v\n", ix+1, want_line, got_line)
+		}
+	}
+}
+
+// show renders an item with its type when the value alone is ambiguous
+func show(item numen.PToken) string {
+	switch item.Type {
+	case numen.P_STRING:
+		return strconv.Quote(item.Value.(string))
+	case numen.P_INT, numen.P_FLOAT, numen.P_SYMBOL:
+		return fmt.Sprintf("%v (%v)", item.Display(), item.Type)
+	}
+	return item.Display()
+}
+
+func show_stack(stack numen.IStack) string {
+	return numen.PToken{Type: numen.P_STACK, Value: numen.NewVector(stack...)}.Display()
+}
+
+func output_note(output string) string {
+	if output == "" {
+		return ""
+	}
+	return "\noutput:\n" + output
+}
+
+func error_note(err error) string {
+	if err == nil {
+		return ""
+	}
+	return fmt.Sprintf("the script failed: %v\n", err)
+}
+
+// RunScripts runs every .nm file matching pattern as a subtest named after
+// the file. Test files (*_test.nm) are skipped, see RunTests. A script
+// must run without an error. If name.out is next to name.nm the output
+// must match it, if name.stack is there the final stack must equal the
+// literals it holds. check, when not nil, adds more expectations.
+func RunScripts(t *testing.T, pattern string, check func(t *testing.T, result *Result)) {
+	t.Helper()
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.nm") || filepath.Ext(path) != ".nm" {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".nm")
+		t.Run(name, func(t *testing.T) {
+			result := Run(t, Script{Path: path})
+			result.ExpectNoError()
+			stem := strings.TrimSuffix(path, ".nm")
+			if output, err := os.ReadFile(stem + ".out"); err == nil {
+				result.ExpectOutput(string(output))
+			}
+			if stack, err := os.ReadFile(stem + ".stack"); err == nil {
+				result.ExpectStackCode(string(stack))
+			}
+			if check != nil {
+				check(t, result)
+			}
+		})
+	}
+}
+
+// RunTests runs the test functions of *_test.nm files like numen test,
+// with a subtest per file and one per test function inside it.
+// Directories in paths are searched recursively.
+func RunTests(t *testing.T, paths ...string) {
+	t.Helper()
+	files, err := numen.FindTestFiles(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".nm"), func(t *testing.T) {
+			var output bytes.Buffer
+			in := interpreter(Script{}, &output)
+			file, err := in.LoadTestFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range file.Names() {
+				t.Run(name, func(t *testing.T) {
+					output.Reset()
+					if _, err := file.Run(name); err != nil {
+						t.Errorf("%v%v", err, output_note(output.String()))
+					}
+				})
+			}
+		})
+	}
+}
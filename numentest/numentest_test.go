@@ -0,0 +1,58 @@
+package numentest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recorder is a testing.TB that keeps the failures instead of reporting them
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestPassingScript(t *testing.T) {
+	Run(t, Script{Code: `1 2 + "sum" println`}).
+		ExpectNoError().
+		ExpectStack(3).
+		ExpectOutput("sum\n")
+	Run(t, Script{Code: "+", Stack: []any{1, 2}}).
+		ExpectNoError().
+		ExpectStackCode("3")
+}
+
+func TestFailingScript(t *testing.T) {
+	result := Run(t, Script{Code: `1 "oops" throw`})
+	result.ExpectError("oops").ExpectStack(1)
+
+	r := &recorder{TB: t}
+	result.t = r
+	result.ExpectNoError().ExpectStack(2)
+	if len(r.failures) != 2 {
+		t.Fatalf("got %v failures, want 2: %q", len(r.failures), r.failures)
+	}
+	if !strings.Contains(r.failures[0], "oops") {
+		t.Errorf("ExpectNoError should report the error, got %q", r.failures[0])
+	}
+	if !strings.Contains(r.failures[1], "want 2 (Integer), got 1 (Integer)") {
+		t.Errorf("ExpectStack should show the differing item, got %q", r.failures[1])
+	}
+}
+
+func TestTestFile(t *testing.T) {
+	dir := t.TempDir()
+	code := "{ 1 2 + 3 assert-eq } 'test_sum store"
+	if err := os.WriteFile(filepath.Join(dir, "sum_test.nm"), []byte(code), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	RunTests(t, dir)
+}
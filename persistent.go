@@ -0,0 +1,338 @@
+package numen
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+)
+
+// Persistent data structures backing stack and memory values. Every update
+// returns a new version that shares most of its structure with the old one,
+// old versions are never modified.
+
+const (
+	node_bits  = 5
+	node_width = 1 << node_bits
+	node_mask  = node_width - 1
+)
+
+// PVector is a persistent vector, a 32-way trie with the last (up to) 32
+// elements kept in a separate tail so appends are cheap.
+type PVector[T any] struct {
+	count int
+	shift uint
+	root  *vectorNode[T]
+	tail  []T
+}
+
+type vectorNode[T any] struct {
+	children []*vectorNode[T]
+	values   []T
+}
+
+func NewVector[T any](items ...T) (vector PVector[T]) {
+	for _, item := range items {
+		vector = vector.Append(item)
+	}
+	return vector
+}
+
+func (v PVector[T]) Len() int {
+	return v.count
+}
+
+func (v PVector[T]) tail_offset() int {
+	if v.count < node_width {
+		return 0
+	}
+	return ((v.count - 1) >> node_bits) << node_bits
+}
+
+// leaf returns the array holding index ix
+func (v PVector[T]) leaf(ix int) []T {
+	if ix >= v.tail_offset() {
+		return v.tail
+	}
+	node := v.root
+	for level := v.shift; level > 0; level -= node_bits {
+		node = node.children[(ix>>level)&node_mask]
+	}
+	return node.values
+}
+
+func (v PVector[T]) Get(ix int) T {
+	if ix < 0 || ix >= v.count {
+		panicf("[VECTOR] index %v out of range for length %v", ix, v.count)
+	}
+	return v.leaf(ix)[ix&node_mask]
+}
+
+// Last returns the most recently appended element
+func (v PVector[T]) Last() T {
+	return v.Get(v.count - 1)
+}
+
+func (v PVector[T]) Append(item T) PVector[T] {
+	if v.root == nil {
+		v.root = &vectorNode[T]{}
+		v.shift = node_bits
+	}
+	// room in the tail
+	if v.count-v.tail_offset() < node_width {
+		new_tail := make([]T, len(v.tail)+1)
+		copy(new_tail, v.tail)
+		new_tail[len(v.tail)] = item
+		return PVector[T]{count: v.count + 1, shift: v.shift, root: v.root, tail: new_tail}
+	}
+	// tail is full, move it into the trie
+	tail_node := &vectorNode[T]{values: v.tail}
+	new_shift := v.shift
+	var new_root *vectorNode[T]
+	if (v.count >> node_bits) > (1 << v.shift) {
+		// root overflow
+		new_root = &vectorNode[T]{children: []*vectorNode[T]{v.root, new_path(v.shift, tail_node)}}
+		new_shift += node_bits
+	} else {
+		new_root = v.push_tail(v.shift, v.root, tail_node)
+	}
+	return PVector[T]{count: v.count + 1, shift: new_shift, root: new_root, tail: []T{item}}
+}
+
+func (v PVector[T]) push_tail(level uint, parent *vectorNode[T], tail_node *vectorNode[T]) *vectorNode[T] {
+	sub_index := ((v.count - 1) >> level) & node_mask
+	result := &vectorNode[T]{children: append([]*vectorNode[T](nil), parent.children...)}
+	var insert *vectorNode[T]
+	if level == node_bits {
+		insert = tail_node
+	} else if sub_index < len(parent.children) {
+		insert = v.push_tail(level-node_bits, parent.children[sub_index], tail_node)
+	} else {
+		insert = new_path(level-node_bits, tail_node)
+	}
+	if sub_index < len(result.children) {
+		result.children[sub_index] = insert
+	} else {
+		result.children = append(result.children, insert)
+	}
+	return result
+}
+
+func new_path[T any](level uint, node *vectorNode[T]) *vectorNode[T] {
+	if level == 0 {
+		return node
+	}
+	return &vectorNode[T]{children: []*vectorNode[T]{new_path(level-node_bits, node)}}
+}
+
+// Set returns a vector with index ix replaced by item
+func (v PVector[T]) Set(ix int, item T) PVector[T] {
+	if ix < 0 || ix >= v.count {
+		panicf("[VECTOR] index %v out of range for length %v", ix, v.count)
+	}
+	if ix >= v.tail_offset() {
+		new_tail := append([]T(nil), v.tail...)
+		new_tail[ix&node_mask] = item
+		return PVector[T]{count: v.count, shift: v.shift, root: v.root, tail: new_tail}
+	}
+	return PVector[T]{count: v.count, shift: v.shift, root: assoc_path(v.shift, v.root, ix, item), tail: v.tail}
+}
+
+func assoc_path[T any](level uint, node *vectorNode[T], ix int, item T) *vectorNode[T] {
+	if level == 0 {
+		result := &vectorNode[T]{values: append([]T(nil), node.values...)}
+		result.values[ix&node_mask] = item
+		return result
+	}
+	result := &vectorNode[T]{children: append([]*vectorNode[T](nil), node.children...)}
+	sub_index := (ix >> level) & node_mask
+	result.children[sub_index] = assoc_path(level-node_bits, node.children[sub_index], ix, item)
+	return result
+}
+
+// Pop returns the vector without its last element
+func (v PVector[T]) Pop() PVector[T] {
+	if v.count == 0 {
+		panicf("[VECTOR] cannot pop from empty vector")
+	}
+	if v.count == 1 {
+		return PVector[T]{}
+	}
+	if v.count-v.tail_offset() > 1 {
+		// the tail is never written in place, sharing a shorter view is safe
+		return PVector[T]{count: v.count - 1, shift: v.shift, root: v.root, tail: v.tail[:len(v.tail)-1]}
+	}
+	new_tail := v.leaf(v.count - 2)
+	new_root := v.pop_tail(v.shift, v.root)
+	new_shift := v.shift
+	if new_root == nil {
+		new_root = &vectorNode[T]{}
+	}
+	if new_shift > node_bits && len(new_root.children) == 1 {
+		new_root = new_root.children[0]
+		new_shift -= node_bits
+	}
+	return PVector[T]{count: v.count - 1, shift: new_shift, root: new_root, tail: new_tail}
+}
+
+func (v PVector[T]) pop_tail(level uint, node *vectorNode[T]) *vectorNode[T] {
+	sub_index := ((v.count - 2) >> level) & node_mask
+	if level > node_bits {
+		new_child := v.pop_tail(level-node_bits, node.children[sub_index])
+		if new_child == nil && sub_index == 0 {
+			return nil
+		}
+		result := &vectorNode[T]{children: append([]*vectorNode[T](nil), node.children[:sub_index+1]...)}
+		if new_child == nil {
+			result.children = result.children[:sub_index]
+		} else {
+			result.children[sub_index] = new_child
+		}
+		return result
+	} else if sub_index == 0 {
+		return nil
+	}
+	return &vectorNode[T]{children: append([]*vectorNode[T](nil), node.children[:sub_index]...)}
+}
+
+// Each calls fn for every element in order
+func (v PVector[T]) Each(fn func(ix int, item T)) {
+	for start := 0; start < v.count; start += node_width {
+		for offset, item := range v.leaf(start) {
+			fn(start+offset, item)
+		}
+	}
+}
+
+func (v PVector[T]) ToSlice() []T {
+	result := make([]T, 0, v.count)
+	v.Each(func(_ int, item T) {
+		result = append(result, item)
+	})
+	return result
+}
+
+func (v PVector[T]) String() string {
+	return fmt.Sprint(v.ToSlice())
+}
+
+// PHashMap is a persistent string keyed hash array mapped trie
+type PHashMap[V any] struct {
+	count int
+	root  *hamtNode[V]
+}
+
+// A node holds one entry per set bit of bitmap, an entry is either a leaf
+// or a child node. Past the last hash bits a node keeps colliding leaves in
+// a plain list instead.
+type hamtNode[V any] struct {
+	bitmap  uint32
+	entries []hamtEntry[V]
+}
+
+type hamtEntry[V any] struct {
+	hash  uint64
+	key   string
+	value V
+	child *hamtNode[V]
+}
+
+const hash_bits = 64
+
+func hash_key(key string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(key))
+	return hasher.Sum64()
+}
+
+func (m PHashMap[V]) Len() int {
+	return m.count
+}
+
+func (m PHashMap[V]) Get(key string) (V, bool) {
+	return m.get(hash_key(key), key)
+}
+
+// get finds key by its hash, tests pass colliding hashes directly
+func (m PHashMap[V]) get(hash uint64, key string) (value V, ok bool) {
+	node := m.root
+	for shift := uint(0); node != nil; shift += node_bits {
+		if shift >= hash_bits {
+			for _, entry := range node.entries {
+				if entry.key == key {
+					return entry.value, true
+				}
+			}
+			return value, false
+		}
+		bit := uint32(1) << ((hash >> shift) & node_mask)
+		if node.bitmap&bit == 0 {
+			return value, false
+		}
+		entry := node.entries[bits.OnesCount32(node.bitmap&(bit-1))]
+		if entry.child == nil {
+			if entry.key == key {
+				return entry.value, true
+			}
+			return value, false
+		}
+		node = entry.child
+	}
+	return value, false
+}
+
+// Set returns a map with key bound to value
+func (m PHashMap[V]) Set(key string, value V) PHashMap[V] {
+	return m.set(hash_key(key), key, value)
+}
+
+func (m PHashMap[V]) set(hash uint64, key string, value V) PHashMap[V] {
+	new_root, added := hamt_set(m.root, 0, hamtEntry[V]{hash: hash, key: key, value: value})
+	if added {
+		return PHashMap[V]{count: m.count + 1, root: new_root}
+	}
+	return PHashMap[V]{count: m.count, root: new_root}
+}
+
+func hamt_set[V any](node *hamtNode[V], shift uint, leaf hamtEntry[V]) (*hamtNode[V], bool) {
+	if node == nil {
+		node = &hamtNode[V]{}
+	}
+	if shift >= hash_bits {
+		for ix, entry := range node.entries {
+			if entry.key == leaf.key {
+				result := &hamtNode[V]{entries: append([]hamtEntry[V](nil), node.entries...)}
+				result.entries[ix] = leaf
+				return result, false
+			}
+		}
+		entries := make([]hamtEntry[V], len(node.entries), len(node.entries)+1)
+		copy(entries, node.entries)
+		return &hamtNode[V]{entries: append(entries, leaf)}, true
+	}
+
+	bit := uint32(1) << ((leaf.hash >> shift) & node_mask)
+	ix := bits.OnesCount32(node.bitmap & (bit - 1))
+	if node.bitmap&bit == 0 {
+		entries := make([]hamtEntry[V], 0, len(node.entries)+1)
+		entries = append(entries, node.entries[:ix]...)
+		entries = append(entries, leaf)
+		entries = append(entries, node.entries[ix:]...)
+		return &hamtNode[V]{bitmap: node.bitmap | bit, entries: entries}, true
+	}
+
+	result := &hamtNode[V]{bitmap: node.bitmap, entries: append([]hamtEntry[V](nil), node.entries...)}
+	entry := node.entries[ix]
+	added := false
+	if entry.child != nil {
+		result.entries[ix].child, added = hamt_set(entry.child, shift+node_bits, leaf)
+	} else if entry.key == leaf.key {
+		result.entries[ix] = leaf
+	} else {
+		// split the leaf into a child node holding both entries
+		child, _ := hamt_set(nil, shift+node_bits, entry)
+		child, _ = hamt_set(child, shift+node_bits, leaf)
+		result.entries[ix] = hamtEntry[V]{child: child}
+		added = true
+	}
+	return result, added
+}
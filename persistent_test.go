@@ -0,0 +1,158 @@
+package numen
+
+import (
+	"fmt"
+	"testing"
+)
+
+func numbers(count int) PVector[int] {
+	var vector PVector[int]
+	for ix := 0; ix < count; ix++ {
+		vector = vector.Append(ix)
+	}
+	return vector
+}
+
+func check_numbers(t *testing.T, vector PVector[int], count int) {
+	t.Helper()
+	if vector.Len() != count {
+		t.Fatalf("Len() = %v, want %v", vector.Len(), count)
+	}
+	for ix := 0; ix < count; ix++ {
+		if got := vector.Get(ix); got != ix {
+			t.Fatalf("Get(%v) = %v in a vector of %v", ix, got, count)
+		}
+	}
+	seen := 0
+	vector.Each(func(ix int, item int) {
+		if ix != seen || item != ix {
+			t.Fatalf("Each gave %v at %v, want %v", item, ix, seen)
+		}
+		seen++
+	})
+	if seen != count {
+		t.Fatalf("Each visited %v items, want %v", seen, count)
+	}
+}
+
+// sizes around the tail (32), the first trie level (32+32*32) and the
+// second root overflow (32*32*32)
+var vector_sizes = []int{0, 1, 31, 32, 33, 63, 64, 65, 1023, 1024, 1025, 1056, 1057, 1088, 32768, 32800, 32801, 32833}
+
+func TestVectorAppend(t *testing.T) {
+	for _, size := range vector_sizes {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			check_numbers(t, numbers(size), size)
+		})
+	}
+}
+
+func TestVectorPop(t *testing.T) {
+	for _, size := range vector_sizes {
+		if size == 0 {
+			continue
+		}
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			vector := numbers(size)
+			popped := vector.Pop()
+			check_numbers(t, popped, size-1)
+			check_numbers(t, vector, size)
+			// popping across a node boundary and appending again
+			check_numbers(t, popped.Append(size-1), size)
+		})
+	}
+}
+
+func TestVectorPopToEmpty(t *testing.T) {
+	size := 1100
+	vector := numbers(size)
+	for count := size; count > 0; count-- {
+		if vector.Last() != count-1 {
+			t.Fatalf("Last() = %v with %v items", vector.Last(), count)
+		}
+		vector = vector.Pop()
+		if count%32 <= 1 {
+			check_numbers(t, vector, count-1)
+		}
+	}
+	check_numbers(t, vector, 0)
+	check_numbers(t, vector.Append(0).Append(1), 2)
+}
+
+func TestVectorSet(t *testing.T) {
+	for _, size := range []int{1, 32, 33, 1025, 32801} {
+		vector := numbers(size)
+		for _, ix := range []int{0, size / 2, size - 1} {
+			changed := vector.Set(ix, -1)
+			if changed.Get(ix) != -1 {
+				t.Errorf("Set(%v) did not change a vector of %v", ix, size)
+			}
+			check_numbers(t, vector, size)
+			check_numbers(t, changed.Set(ix, ix), size)
+		}
+	}
+}
+
+func TestHashMap(t *testing.T) {
+	var m PHashMap[int]
+	for ix := 0; ix < 5000; ix++ {
+		m = m.Set(fmt.Sprint("key", ix), ix)
+	}
+	if m.Len() != 5000 {
+		t.Fatalf("Len() = %v, want 5000", m.Len())
+	}
+	replaced := m.Set("key7", -7)
+	for ix := 0; ix < 5000; ix++ {
+		if value, ok := m.Get(fmt.Sprint("key", ix)); !ok || value != ix {
+			t.Fatalf("Get(key%v) = %v, %v", ix, value, ok)
+		}
+	}
+	if value, _ := replaced.Get("key7"); value != -7 || replaced.Len() != 5000 {
+		t.Errorf("replacing key7 gave %v and length %v", value, replaced.Len())
+	}
+	if _, ok := m.Get("missing"); ok {
+		t.Errorf("Get(missing) found a value")
+	}
+}
+
+func TestHashMapCollisions(t *testing.T) {
+	tests := []struct {
+		name   string
+		hashes []uint64
+	}{
+		{"full hash", []uint64{42, 42, 42}},
+		{"first level", []uint64{1, 1 + 32, 1 + 64}},
+		{"all but the last bits", []uint64{7, 7 | 1<<63, 7 | 1<<62}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var m PHashMap[int]
+			for ix, hash := range test.hashes {
+				m = m.set(hash, fmt.Sprint("k", ix), ix)
+			}
+			if m.Len() != len(test.hashes) {
+				t.Fatalf("Len() = %v, want %v", m.Len(), len(test.hashes))
+			}
+			before := m
+			m = m.set(test.hashes[1], "k1", 10)
+			if m.Len() != len(test.hashes) {
+				t.Fatalf("replacing a colliding key changed Len() to %v", m.Len())
+			}
+			for ix, hash := range test.hashes {
+				want := ix
+				if ix == 1 {
+					want = 10
+				}
+				if value, ok := m.get(hash, fmt.Sprint("k", ix)); !ok || value != want {
+					t.Errorf("get(k%v) = %v, %v, want %v", ix, value, ok, want)
+				}
+				if value, _ := before.get(hash, fmt.Sprint("k", ix)); value != ix {
+					t.Errorf("the old map changed, k%v = %v", ix, value)
+				}
+			}
+			if _, ok := m.get(test.hashes[0], "other"); ok {
+				t.Errorf("found a key that was never set under a colliding hash")
+			}
+		})
+	}
+}
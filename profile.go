@@ -0,0 +1,306 @@
+package numen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Profiler times builtins, user words and blocks. Every run is a frame on a
+// stack of frames, self time leaves out the time of nested frames and
+// cumulative time includes it.
+type Profiler struct {
+	functions map[string]*profileFunction
+	order     []*profileFunction // in the order they first ran
+	frames    []*profileFrame
+	root      *profilePath
+	start     time.Time
+	next_name string // names the next block frame, set by user words and call
+	// blocks loaded from a variable, by where their code starts, so work call
+	// and 'work call both show as work
+	block_names map[Position]string
+}
+
+type profileFunction struct {
+	id     int
+	name   string
+	pos    Position // where the code starts, empty for builtins
+	calls  int64
+	self   time.Duration
+	cum    time.Duration
+	active int // running frames, recursion only counts the outer one in cum
+}
+
+type profileFrame struct {
+	function *profileFunction
+	path     *profilePath
+	start    time.Time
+	children time.Duration
+}
+
+// profilePath is a node in the tree of call stacks seen so far, pprof gets
+// one sample per node
+type profilePath struct {
+	function *profileFunction
+	parent   *profilePath
+	children map[*profileFunction]*profilePath
+	calls    int64
+	self     time.Duration
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		functions:   map[string]*profileFunction{},
+		block_names: map[Position]string{},
+		root:        &profilePath{children: map[*profileFunction]*profilePath{}},
+		start:       time.Now(),
+	}
+}
+
+func WithProfiler(profiler *Profiler) Option {
+	return func(in *Interpreter) {
+		in.Profiler = profiler
+	}
+}
+
+func (p *Profiler) function(name string, pos Position) *profileFunction {
+	function, ok := p.functions[name]
+	if !ok {
+		function = &profileFunction{id: len(p.order) + 1, name: name, pos: pos}
+		p.functions[name] = function
+		p.order = append(p.order, function)
+	}
+	return function
+}
+
+// name_next names the frame of the next block run, instead of its position
+func (p *Profiler) name_next(name string) {
+	p.next_name = name
+}
+
+// name_value remembers the variable a block or function memory was loaded
+// from, its frames are named after it
+func (p *Profiler) name_value(name string, value PToken) {
+	if block, ok := test_block(value); ok {
+		p.block_names[block.Pos] = name
+	}
+}
+
+// begin_builtin starts the frame of a builtin, the returned call ends it
+func (p *Profiler) begin_builtin(name string) func() {
+	return p.begin(p.function(name, Position{}))
+}
+
+// begin_block starts the frame of a run_function call, the returned call
+// ends it
+func (p *Profiler) begin_block(pos Position) func() {
+	name := p.next_name
+	p.next_name = ""
+	if name == "" {
+		name = p.block_names[pos]
+	}
+	if name == "" {
+		if pos.Line == 1 && pos.Col == 1 {
+			// a program or a module, blocks start after their {
+			name = pos.File
+		} else {
+			name = "{ } " + pos.String()
+		}
+	}
+	return p.begin(p.function(name, pos))
+}
+
+func (p *Profiler) begin(function *profileFunction) func() {
+	parent := p.root
+	if len(p.frames) > 0 {
+		parent = p.frames[len(p.frames)-1].path
+	}
+	path, ok := parent.children[function]
+	if !ok {
+		path = &profilePath{function: function, parent: parent, children: map[*profileFunction]*profilePath{}}
+		parent.children[function] = path
+	}
+	frame := &profileFrame{function: function, path: path, start: time.Now()}
+	function.calls++
+	function.active++
+	p.frames = append(p.frames, frame)
+	return func() {
+		p.end(frame)
+	}
+}
+
+func (p *Profiler) end(frame *profileFrame) {
+	elapsed := time.Since(frame.start)
+	self := elapsed - frame.children
+	function := frame.function
+	function.self += self
+	function.active--
+	if function.active == 0 {
+		function.cum += elapsed
+	}
+	frame.path.calls++
+	frame.path.self += self
+	p.frames = p.frames[:len(p.frames)-1]
+	if len(p.frames) > 0 {
+		p.frames[len(p.frames)-1].children += elapsed
+	}
+}
+
+// WriteText writes a table of every function, most self time first
+func (p *Profiler) WriteText(out io.Writer) {
+	total := time.Since(p.start)
+	functions := append([]*profileFunction(nil), p.order...)
+	sort.SliceStable(functions, func(a, b int) bool {
+		return functions[a].self > functions[b].self
+	})
+	percent := func(part time.Duration) float64 {
+		if total <= 0 {
+			return 0
+		}
+		return 100 * float64(part) / float64(total)
+	}
+	fmt.Fprintf(out, "total %v\n", total.Round(time.Microsecond))
+	fmt.Fprintf(out, "%10v %12v %7v %12v %7v  %v\n", "calls", "self", "self%", "cum", "cum%", "name")
+	for _, function := range functions {
+		fmt.Fprintf(out, "%10v %12v %6.2f%% %12v %6.2f%%  %v\n", function.calls,
+			function.self.Round(time.Microsecond), percent(function.self),
+			function.cum.Round(time.Microsecond), percent(function.cum), function.name)
+	}
+}
+
+// WritePprof writes a gzipped pprof profile with a calls and a time value
+// per call stack
+func (p *Profiler) WritePprof(out io.Writer) error {
+	var profile protoBuffer
+	string_ids := map[string]int{}
+	string_index := func(text string) uint64 {
+		ix, ok := string_ids[text]
+		if !ok {
+			ix = len(string_ids)
+			string_ids[text] = ix
+		}
+		return uint64(ix)
+	}
+	string_index("")
+	value_type := func(kind string, unit string) *protoBuffer {
+		var message protoBuffer
+		message.uint64_field(1, string_index(kind))
+		message.uint64_field(2, string_index(unit))
+		return &message
+	}
+
+	// sample_type
+	profile.message(1, value_type("calls", "count"))
+	profile.message(1, value_type("time", "nanoseconds"))
+	// sample, one per call stack, the leaf location comes first
+	var add_samples func(path *profilePath)
+	add_samples = func(path *profilePath) {
+		if path.function != nil && path.calls > 0 {
+			var locations []uint64
+			for node := path; node.function != nil; node = node.parent {
+				locations = append(locations, uint64(node.function.id))
+			}
+			var sample protoBuffer
+			sample.packed(1, locations)
+			sample.packed(2, []uint64{uint64(path.calls), uint64(path.self.Nanoseconds())})
+			profile.message(2, &sample)
+		}
+		children := make([]*profilePath, 0, len(path.children))
+		for _, child := range path.children {
+			children = append(children, child)
+		}
+		sort.Slice(children, func(a, b int) bool {
+			return children[a].function.id < children[b].function.id
+		})
+		for _, child := range children {
+			add_samples(child)
+		}
+	}
+	add_samples(p.root)
+	// location and function, one of each per profiled function
+	for _, function := range p.order {
+		var line protoBuffer
+		line.uint64_field(1, uint64(function.id))
+		line.uint64_field(2, uint64(function.pos.Line))
+		var location protoBuffer
+		location.uint64_field(1, uint64(function.id))
+		location.message(4, &line)
+		profile.message(4, &location)
+	}
+	for _, function := range p.order {
+		var message protoBuffer
+		message.uint64_field(1, uint64(function.id))
+		message.uint64_field(2, string_index(function.name))
+		message.uint64_field(3, string_index(function.name))
+		message.uint64_field(4, string_index(function.pos.File))
+		message.uint64_field(5, uint64(function.pos.Line))
+		profile.message(5, &message)
+	}
+	profile.uint64_field(9, uint64(p.start.UnixNano()))
+	profile.uint64_field(10, uint64(time.Since(p.start).Nanoseconds()))
+	profile.message(11, value_type("time", "nanoseconds"))
+	profile.uint64_field(12, 1)
+	// string_table last, every string is known by now
+	table := make([]string, len(string_ids))
+	for text, ix := range string_ids {
+		table[ix] = text
+	}
+	for _, text := range table {
+		profile.bytes_field(6, []byte(text))
+	}
+
+	compressed := gzip.NewWriter(out)
+	if _, err := compressed.Write(profile.Bytes()); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
+
+// protoBuffer writes the protobuf wire format, just enough for pprof
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (b *protoBuffer) varint(value uint64) {
+	for value >= 0x80 {
+		b.WriteByte(byte(value) | 0x80)
+		value >>= 7
+	}
+	b.WriteByte(byte(value))
+}
+
+func (b *protoBuffer) key(field int, wire_type int) {
+	b.varint(uint64(field)<<3 | uint64(wire_type))
+}
+
+// uint64_field writes a varint field, zero is the default and left out
+func (b *protoBuffer) uint64_field(field int, value uint64) {
+	if value == 0 {
+		return
+	}
+	b.key(field, 0)
+	b.varint(value)
+}
+
+// bytes_field writes a length delimited field, strings always, as the
+// string table needs its empty first entry
+func (b *protoBuffer) bytes_field(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *protoBuffer) message(field int, message *protoBuffer) {
+	b.bytes_field(field, message.Bytes())
+}
+
+func (b *protoBuffer) packed(field int, values []uint64) {
+	var data protoBuffer
+	for _, value := range values {
+		data.varint(value)
+	}
+	b.bytes_field(field, data.Bytes())
+}
@@ -0,0 +1,30 @@
+package numen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileNamesCalledFunctions(t *testing.T) {
+	profiler := NewProfiler()
+	code := `
+		[ code { 1 drop } ] 'work store
+		{ 2 drop } 'job store
+		work call
+		'work call
+		job run
+	`
+	if _, err := NewInterpreter(WithProfiler(profiler)).Run("p.nm", code); err != nil {
+		t.Fatal(err)
+	}
+	for name, calls := range map[string]int64{"work": 2, "job": 1} {
+		if function, ok := profiler.functions[name]; !ok || function.calls != calls {
+			t.Errorf("%v ran %v times in the profile, want %v", name, function, calls)
+		}
+	}
+	for name := range profiler.functions {
+		if strings.HasPrefix(name, "{ }") {
+			t.Errorf("the profile names a stored block by its position: %v", name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package numen
+
+// IScope is one level of variables. Lookups walk outwards through parent
+// until the global scope, which has none.
+type IScope struct {
+	vars   map[string]PToken
+	order  []string // the names of vars in the order they were first bound
+	parent *IScope
+	words  map[string][]IBlock // the dictionary of a module, on its top scope only
+}
+
+func NewScope(parent *IScope) *IScope {
+	return &IScope{vars: map[string]PToken{}, parent: parent}
+}
+
+// Lookup finds the innermost binding of name
+func (sc *IScope) Lookup(name string) (PToken, bool) {
+	value, _, ok := sc.find(name)
+	return value, ok
+}
+
+// find returns the binding of name and the scope that holds it
+func (sc *IScope) find(name string) (PToken, *IScope, bool) {
+	for scope := sc; scope != nil; scope = scope.parent {
+		if value, ok := scope.vars[name]; ok {
+			return value, scope, true
+		}
+	}
+	return PToken{}, nil, false
+}
+
+// Define binds name in this scope, shadowing outer bindings
+func (sc *IScope) Define(name string, value PToken) {
+	if _, ok := sc.vars[name]; !ok {
+		sc.order = append(sc.order, name)
+	}
+	sc.vars[name] = value
+}
+
+// Set updates the innermost existing binding of name, it reports false if
+// name is not bound anywhere.
+func (sc *IScope) Set(name string, value PToken) bool {
+	_, scope, ok := sc.find(name)
+	if ok {
+		scope.vars[name] = value
+	}
+	return ok
+}
+
+// IBlock is the value of a P_BLOCK token, the code and the scope it was
+// created in.
+type IBlock struct {
+	Code string
+	Env  *IScope
+	Pos  Position // where Code starts in the source
+}
+
+// env is the scope the block runs under, blocks that were never captured
+// fall back to the current scope.
+func (block IBlock) env() *IScope {
+	if block.Env == nil {
+		return currentScope
+	}
+	return block.Env
+}
+
+// capture binds every block in token that has no scope yet to scope,
+// including blocks nested in stack and memory literals.
+func capture(token PToken, scope *IScope) (PToken, bool) {
+	if token.Type == P_BLOCK {
+		block := token.Value.(IBlock)
+		if block.Env != nil {
+			return token, false
+		}
+		block.Env = scope
+		return PToken{P_BLOCK, block}, true
+	} else if token.Type == P_STACK {
+		stack := token.Value.(IList)
+		changed := false
+		stack.Each(func(ix int, item PToken) {
+			if captured, ok := capture(item, scope); ok {
+				stack = stack.Set(ix, captured)
+				changed = true
+			}
+		})
+		return PToken{P_STACK, stack}, changed
+	} else if token.Type == P_MEMORY {
+		memory := token.Value.(IMemory)
+		changed := false
+		memory.Each(func(key string, value PToken) {
+			if captured, ok := capture(value, scope); ok {
+				memory = memory.Set(key, captured)
+				changed = true
+			}
+		})
+		return PToken{P_MEMORY, memory}, changed
+	}
+	return token, false
+}
+
+// run_block runs a block in a fresh scope below the one it captured
+func run_block(block IBlock) {
+	run_function(block.Code, block.Pos, NewScope(block.env()))
+}
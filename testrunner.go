@@ -0,0 +1,336 @@
+package numen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// numen test runs the test functions of *_test.nm files. A test is a block
+// or function memory stored under a name starting with test, or a user word
+// named that way. The top level of a file runs once, every test starts from
+// the variables and words it left, on an empty stack.
+
+// TestOptions configures run_tests
+type TestOptions struct {
+	Run     *regexp.Regexp // only tests whose name matches, nil runs all
+	Verbose bool           // print passing tests and their output
+	Update  bool           // rewrite the golden .out files
+	Out     io.Writer      // where results are reported
+}
+
+// testCase is one test function of a file
+type testCase struct {
+	name string
+	pos  Position
+}
+
+// find_test_files lists the *_test.nm files in paths, directories are
+// searched recursively
+func find_test_files(paths []string) ([]string, error) {
+	return find_files(paths, "_test.nm")
+}
+
+// find_files lists the files in paths, in directories only those whose
+// name ends in suffix
+func find_files(paths []string, suffix string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && strings.HasSuffix(file, suffix) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// load_test_file runs the top level of a test file on fresh globals, its
+// output is dropped
+func load_test_file(path string, code string) error {
+	reset_globals()
+	interp.loading = nil
+	if abs, err := filepath.Abs(path); err == nil {
+		interp.loading = append(interp.loading, abs)
+	}
+	saved_stdout := interp.Stdout
+	interp.Stdout = io.Discard
+	defer func() {
+		interp.Stdout = saved_stdout
+	}()
+	return protect(func() {
+		run_function(code, Position{path, 1, 1}, globalScope)
+	})
+}
+
+// testState is what the top level of a test file left behind
+type testState struct {
+	scope   *IScope
+	vars    map[string]PToken
+	order   []string
+	words   map[string][]IBlock
+	loading []string
+}
+
+func save_test_state() testState {
+	state := testState{
+		scope:   globalScope,
+		vars:    maps.Clone(globalScope.vars),
+		order:   slices.Clone(globalScope.order),
+		words:   map[string][]IBlock{},
+		loading: slices.Clone(interp.loading),
+	}
+	for name, definitions := range userWords {
+		state.words[name] = slices.Clone(definitions)
+	}
+	return state
+}
+
+// restore undoes what a test changed, also after another file was loaded.
+// Blocks captured the global scope, so it is refilled in place.
+func (state testState) restore() {
+	globalScope = state.scope
+	clear(globalScope.vars)
+	maps.Copy(globalScope.vars, state.vars)
+	globalScope.order = slices.Clone(state.order)
+	userWords = map[string][]IBlock{}
+	for name, definitions := range state.words {
+		userWords[name] = slices.Clone(definitions)
+	}
+	interp.loading = slices.Clone(state.loading)
+	overriding = map[string]int{}
+	globalStack = nil
+	currentScope = globalScope
+	loopStack = nil
+}
+
+// test_cases lists the tests the loaded file defines, in source order
+func test_cases() (cases []testCase) {
+	for name, value := range globalScope.vars {
+		if block, ok := test_block(value); ok && strings.HasPrefix(name, "test") {
+			cases = append(cases, testCase{name, block.Pos})
+		}
+	}
+	for name, definitions := range userWords {
+		if strings.HasPrefix(name, "test") {
+			cases = append(cases, testCase{name, definitions[len(definitions)-1].Pos})
+		}
+	}
+	sort.Slice(cases, func(a, b int) bool {
+		if cases[a].pos == cases[b].pos {
+			return cases[a].name < cases[b].name
+		}
+		return before(cases[a].pos, cases[b].pos)
+	})
+	return cases
+}
+
+// test_block is the code of a stored block or function memory
+func test_block(value PToken) (IBlock, bool) {
+	if value.Type == P_BLOCK {
+		return value.Value.(IBlock), true
+	}
+	if value.Type == P_MEMORY {
+		code, ok := value.Value.(IMemory).Get("code")
+		if ok && code.Type == P_BLOCK {
+			return code.Value.(IBlock), true
+		}
+	}
+	return IBlock{}, false
+}
+
+// run_test runs one test of the loaded file with an empty stack
+func run_test(test testCase) error {
+	globalStack = nil
+	return protect(func() {
+		if definitions, ok := userWords[test.name]; ok {
+			run_word(test.name, definitions[len(definitions)-1])
+			return
+		}
+		value, _ := globalScope.Lookup(test.name)
+		block, _ := test_block(value)
+		run_block(block)
+	})
+}
+
+// run_tests runs the tests of every file and reports them, it returns
+// false if any test failed
+func run_tests(files []string, options TestOptions) bool {
+	passed := true
+	for _, path := range files {
+		if !run_test_file(path, options) {
+			passed = false
+		}
+	}
+	return passed
+}
+
+func run_test_file(path string, options TestOptions) bool {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(options.Out, "FAIL %v: %v\n", path, err)
+		return false
+	}
+	code := string(source)
+	if err := load_test_file(path, code); err != nil {
+		fmt.Fprintf(options.Out, "FAIL %v: %v\n", path, err)
+		return false
+	}
+
+	state := save_test_state()
+
+	var output bytes.Buffer // what the tests printed, for the golden file
+	failed, ran := 0, 0
+	for _, test := range test_cases() {
+		if options.Run != nil && !options.Run.MatchString(test.name) {
+			continue
+		}
+		ran++
+		var test_output bytes.Buffer
+		state.restore()
+		saved_stdout := interp.Stdout
+		interp.Stdout = &test_output
+		err := run_test(test)
+		interp.Stdout = saved_stdout
+		output.Write(test_output.Bytes())
+		if err != nil {
+			failed++
+			fmt.Fprintf(options.Out, "--- FAIL: %v (%v)\n", test.name, test.pos)
+			fmt.Fprintf(options.Out, "    %v\n", err)
+		} else if options.Verbose {
+			fmt.Fprintf(options.Out, "--- PASS: %v (%v)\n", test.name, test.pos)
+		}
+		if test_output.Len() > 0 && (err != nil || options.Verbose) {
+			fmt.Fprint(options.Out, indent(test_output.String(), "    | "))
+		}
+	}
+
+	// the golden file holds the output of all tests, a filtered run only
+	// has part of it
+	golden := strings.TrimSuffix(path, ".nm") + ".out"
+	golden_failed := false
+	if options.Run == nil {
+		if options.Update {
+			if err := os.WriteFile(golden, output.Bytes(), 0o644); err != nil {
+				fmt.Fprintf(options.Out, "--- FAIL: %v: %v\n", golden, err)
+				golden_failed = true
+			}
+		} else if expected, err := os.ReadFile(golden); err == nil && !bytes.Equal(expected, output.Bytes()) {
+			golden_failed = true
+			fmt.Fprintf(options.Out, "--- FAIL: output differs from %v\n", golden)
+			fmt.Fprint(options.Out, indent(line_diff(string(expected), output.String()), "    "))
+		}
+	}
+
+	// the golden file is reported on its own, it is not a test
+	if failed > 0 || golden_failed {
+		summary := fmt.Sprintf("%v of %v tests failed", failed, ran)
+		if golden_failed {
+			summary += ", the output differs from " + golden
+		}
+		fmt.Fprintf(options.Out, "FAIL %v: %v\n", path, summary)
+		return false
+	}
+	fmt.Fprintf(options.Out, "ok   %v: %v tests\n", path, ran)
+	return true
+}
+
+// indent puts prefix before every line of text
+func indent(text string, prefix string) string {
+	lines := strings.SplitAfter(text, "\n")
+	var builder strings.Builder
+	for _, line := range lines {
+		if line != "" {
+			builder.WriteString(prefix + line)
+		}
+	}
+	if !strings.HasSuffix(text, "\n") {
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// line_diff shows the first line where got differs from expected
+func line_diff(expected string, got string) string {
+	expected_lines := strings.Split(expected, "\n")
+	got_lines := strings.Split(got, "\n")
+	for ix := 0; ix < max(len(expected_lines), len(got_lines)); ix++ {
+		var want, have string
+		if ix < len(expected_lines) {
+			want = expected_lines[ix]
+		}
+		if ix < len(got_lines) {
+			have = got_lines[ix]
+		}
+		if ix >= len(expected_lines) || ix >= len(got_lines) || want != have {
+			return fmt.Sprintf("line %v:\nwant %q\ngot  %q\n", ix+1, want, have)
+		}
+	}
+	return ""
+}
+
+func assert_error(format string, args ...any) *NumenError {
+	return new_error("assert", currentPos, fmt.Sprintf(format, args...))
+}
+
+var testBuiltins = map[string]func(){
+	"assert": func() {
+		// ( condition -- )
+		if !globalStack.PopBoolean() {
+			panic(assert_error("[ASSERT] assertion failed"))
+		}
+	},
+	"assert-eq": func() {
+		// ( actual expected -- ) compares like ==
+		expected := globalStack.PopAny()
+		actual := globalStack.PopAny()
+		if !actual.Equal(expected) {
+			panic(assert_error("[ASSERT-EQ] expected %v, got %v", display_nested(expected), display_nested(actual)))
+		}
+	},
+	"assert-stack": func() {
+		// Syntax: ( 1 2 3 ) assert-stack, the whole stack must equal the
+		// stack literal, bottom first
+		expected := globalStack.PopStack()
+		actual := PToken{P_STACK, NewVector(globalStack...)}
+		if !actual.Equal(PToken{P_STACK, expected}) {
+			panic(assert_error("[ASSERT-STACK] expected %v, stack is %v", display_nested(PToken{P_STACK, expected}), display_nested(actual)))
+		}
+	},
+	"assert-throws": func() {
+		// Syntax: { body } assert-throws, the body must raise an error, the
+		// stack is put back as it was before the body
+		body := globalStack.PopBlock()
+		saved_stack := append(IStack(nil), globalStack...)
+		err := protect(func() {
+			run_block(body)
+		})
+		if err == nil {
+			panic(assert_error("[ASSERT-THROWS] expected an error, the block ran without one"))
+		}
+		globalStack = saved_stack
+	},
+}
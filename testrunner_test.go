@@ -0,0 +1,43 @@
+package numen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProtectReturnsNil(t *testing.T) {
+	var err error = protect(func() {})
+	if err != nil {
+		t.Errorf("protect of a clean run returned %#v, want nil", err)
+	}
+}
+
+func TestTopLevelRunsOnce(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"state_test.nm": `
+			"x" "log.txt" appendfile
+			0 'count store
+			{ count 1 + 'count set count 1 assert-eq } 'test_first store
+			{ count 1 + 'count set count 1 assert-eq "out" println } 'test_second store
+		`,
+		"state_test.out": "other\n",
+	})
+	saved_root := interp.Root
+	interp.Root = dir
+	defer func() {
+		interp.Root = saved_root
+	}()
+
+	var out bytes.Buffer
+	passed := run_tests([]string{filepath.Join(dir, "state_test.nm")}, TestOptions{Out: &out})
+	if log, _ := os.ReadFile(filepath.Join(dir, "log.txt")); string(log) != "x" {
+		t.Errorf("the top level ran %v times, want once", len(log))
+	}
+	report := out.String()
+	if passed || !strings.Contains(report, "0 of 2 tests failed, the output differs from") {
+		t.Errorf("a golden mismatch should fail the file but not count as a test, got:\n%v", report)
+	}
+}
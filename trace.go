@@ -0,0 +1,135 @@
+package numen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Tracer is told about every word the interpreter runs. Trace is called
+// once the word finished, so the words run by a block or a user word are
+// traced before the word that ran them.
+type Tracer interface {
+	Trace(event TraceEvent)
+}
+
+// TraceEvent is one word run
+type TraceEvent struct {
+	Word   PWord
+	Depth  int    // run_function nesting, the program itself is 1
+	Before IStack // the stack before the word
+	After  IStack // the stack after the word, or when it failed
+	Err    *NumenError
+}
+
+// TraceFilter picks the words that are traced, the zero value traces all
+type TraceFilter struct {
+	Words    []string // only these words, literals are named by their source
+	MaxDepth int      // only words at most this deep
+}
+
+func WithTracer(tracer Tracer) Option {
+	return func(in *Interpreter) {
+		in.Tracer = tracer
+	}
+}
+
+func WithTraceFilter(filter TraceFilter) Option {
+	return func(in *Interpreter) {
+		in.TraceFilter = filter
+	}
+}
+
+func (filter TraceFilter) allows(word PWord, depth int) bool {
+	if filter.MaxDepth > 0 && depth > filter.MaxDepth {
+		return false
+	}
+	return len(filter.Words) == 0 || Contains(word.Repr(), filter.Words...)
+}
+
+// trace_word runs a word and reports it to the tracer
+func trace_word(word PWord, interp_chan chan PWord, scope *IScope) {
+	depth := interp.depth
+	if !interp.TraceFilter.allows(word, depth) {
+		run_word_token(word, interp_chan, scope)
+		return
+	}
+	event := TraceEvent{Word: word, Depth: depth, Before: append(IStack(nil), globalStack...)}
+	defer func() {
+		r := recover()
+		if err, ok := as_error(r).(*NumenError); ok {
+			event.Err = err
+		}
+		event.After = append(IStack(nil), globalStack...)
+		interp.Tracer.Trace(event)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	run_word_token(word, interp_chan, scope)
+}
+
+// TextTracer writes one line per word, indented by depth
+type TextTracer struct {
+	Out io.Writer
+}
+
+func (tracer TextTracer) Trace(event TraceEvent) {
+	line := fmt.Sprintf("%v%v %v  %v -> %v", strings.Repeat("  ", max(event.Depth-1, 0)), event.Word.Pos,
+		one_line(event.Word.Repr()), one_line(display_stack(event.Before)), one_line(display_stack(event.After)))
+	if event.Err != nil {
+		line += "  error: " + event.Err.Message
+	}
+	fmt.Fprintln(tracer.Out, line)
+}
+
+// one_line folds the line breaks of block code into spaces
+func one_line(text string) string {
+	if !strings.ContainsAny(text, "\n\r") {
+		return text
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// JSONTracer writes one JSON object per word, stack items are shown as
+// print shows them inside a stack
+type JSONTracer struct {
+	Out io.Writer
+}
+
+type traceRecord struct {
+	Word   string   `json:"word"`
+	File   string   `json:"file"`
+	Line   int      `json:"line"`
+	Col    int      `json:"col"`
+	Depth  int      `json:"depth"`
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+	Error  string   `json:"error,omitempty"`
+}
+
+func (tracer JSONTracer) Trace(event TraceEvent) {
+	record := traceRecord{
+		Word:   event.Word.Repr(),
+		File:   event.Word.Pos.File,
+		Line:   event.Word.Pos.Line,
+		Col:    event.Word.Pos.Col,
+		Depth:  event.Depth,
+		Before: display_items(event.Before),
+		After:  display_items(event.After),
+	}
+	if event.Err != nil {
+		record.Error = event.Err.Message
+	}
+	data, _ := json.Marshal(record)
+	fmt.Fprintf(tracer.Out, "%s\n", data)
+}
+
+func display_items(stack IStack) []string {
+	items := make([]string, len(stack))
+	for ix, token := range stack {
+		items[ix] = display_nested(token)
+	}
+	return items
+}
@@ -0,0 +1,364 @@
+package numen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// numen vet reports code that runs but is likely wrong: symbols that only
+// push themselves because of a typo, stored variables nobody loads, code
+// after break, loops without a break, values left on the stack at the end
+// and names that clash with builtins.
+
+// VetOptions configures numen vet
+type VetOptions struct {
+	Module bool // the file is imported, names stored at its top level are its exports
+}
+
+// vetList is one block of code, or the whole file
+type vetList struct {
+	words []PWord
+	top   bool
+}
+
+// vetStore is a value 'name store
+type vetStore struct {
+	name string
+	pos  Position
+	top  bool
+}
+
+type vetter struct {
+	path    string
+	words   []PWord
+	checker *checker // parses blocks and knows the user words
+	lists   []vetList
+	names   map[string]bool   // stored names, memory keys and modules
+	targets map[Position]bool // the names of store and set, they are no loads
+	stores  []vetStore
+	used    map[string]bool
+	imports []string          // module names the file imports
+	seen    map[Position]bool // blocks already added to lists
+}
+
+func load_vet(path string) (*vetter, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v := &vetter{
+		path:    path,
+		checker: new_checker(CheckOptions{}),
+		names:   map[string]bool{},
+		targets: map[Position]bool{},
+		used:    map[string]bool{},
+		seen:    map[Position]bool{},
+	}
+	if err := protect(func() {
+		v.words = parse_words(string(source), Position{path, 1, 1})
+		v.checker.collect(v.words)
+		v.add_list(v.words, true)
+		for ix := 0; ix < len(v.lists); ix++ {
+			v.gather(v.lists[ix])
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// VetFile reports likely mistakes in the script at path
+func VetFile(path string, options VetOptions) ([]Diagnostic, error) {
+	v, err := load_vet(path)
+	if err != nil {
+		return nil, err
+	}
+	return v.vet(options)
+}
+
+func (v *vetter) add_list(words []PWord, top bool) {
+	v.lists = append(v.lists, vetList{words, top})
+}
+
+// add_literal adds the blocks of a literal as lists, and remembers the
+// keys of its memories as names
+func (v *vetter) add_literal(token PToken) {
+	if token.Type == P_BLOCK {
+		block := token.Value.(IBlock)
+		if !v.seen[block.Pos] {
+			v.seen[block.Pos] = true
+			v.add_list(v.checker.parse(block), false)
+		}
+	} else if token.Type == P_STACK {
+		token.Value.(IList).Each(func(_ int, item PToken) {
+			v.add_literal(item)
+		})
+	} else if token.Type == P_MEMORY {
+		token.Value.(IMemory).Each(func(key string, value PToken) {
+			v.names[key] = true
+			v.add_literal(value)
+		})
+	}
+}
+
+func is_word(word PWord, names ...string) bool {
+	return word.Type == P_SYMBOL && !word.Quoted && Contains(word.Value.(string), names...)
+}
+
+// definition_names are the indexes of the names and stack effects of the
+// : definitions in words, they never run
+func definition_names(words []PWord) map[int]bool {
+	skip := map[int]bool{}
+	for ix, word := range words {
+		if is_word(word, ":") && ix+1 < len(words) {
+			skip[ix+1] = true
+			if ix+2 < len(words) {
+				if _, ok := effect_annotation(words[ix+2].PToken); ok {
+					skip[ix+2] = true
+				}
+			}
+		}
+	}
+	return skip
+}
+
+// gather finds the names a list binds and the names it uses
+func (v *vetter) gather(list vetList) {
+	skip := definition_names(list.words)
+	for ix, word := range list.words {
+		if word.Type != P_SYMBOL {
+			v.add_literal(word.PToken)
+			continue
+		}
+		if skip[ix] {
+			continue
+		}
+		name := word.Value.(string)
+		if ix > 0 && is_word(word, "store", "set") && list.words[ix-1].Type == P_SYMBOL {
+			target := list.words[ix-1]
+			v.names[target.Value.(string)] = true
+			v.targets[target.Pos] = true
+			if name == "store" {
+				v.stores = append(v.stores, vetStore{target.Value.(string), target.Pos, list.top})
+			}
+		} else if ix > 0 && is_word(word, "import") && list.words[ix-1].Type == P_STRING {
+			module := module_name(list.words[ix-1].Value.(string))
+			v.names[module] = true
+			v.imports = append(v.imports, module)
+		} else if ix > 1 && is_word(word, "loadfrom", "storeto") && list.words[ix-2].Type == P_SYMBOL {
+			// key memory loadfrom, the key may be written without a quote
+			v.names[list.words[ix-2].Value.(string)] = true
+		}
+	}
+	for ix, word := range list.words {
+		if word.Type == P_SYMBOL && !skip[ix] && !v.targets[word.Pos] {
+			root, _, _ := strings.Cut(word.Value.(string), ".")
+			v.used[root] = true
+		}
+	}
+}
+
+func (v *vetter) vet(options VetOptions) ([]Diagnostic, error) {
+	c := v.checker
+	if err := protect(func() {
+		for _, list := range v.lists {
+			v.check_list(list)
+		}
+		for _, store := range v.stores {
+			if v.used[store.name] || store.top && options.Module {
+				continue
+			}
+			if strings.HasSuffix(v.path, "_test.nm") && strings.HasPrefix(store.name, "test") {
+				// numen test finds it
+				continue
+			}
+			c.report(store.pos, "[UNUSED] %v is stored but never loaded", store.name)
+		}
+		v.check_end()
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(c.diagnostics, func(a, b int) bool {
+		return before(c.diagnostics[a].Pos, c.diagnostics[b].Pos)
+	})
+	return c.diagnostics, nil
+}
+
+func (v *vetter) check_list(list vetList) {
+	c := v.checker
+	skip := definition_names(list.words)
+	for ix, word := range list.words {
+		if skip[ix] {
+			if word.Type == P_SYMBOL && is_builtin(word.Value.(string)) {
+				c.report(word.Pos, "[SHADOW] %v is a builtin, : cannot redefine it, use override", word.Value)
+			}
+			continue
+		}
+		if ix > 0 && is_word(list.words[ix-1], "break") && !is_word(word, ";") {
+			c.report(word.Pos, "[UNREACHABLE] %v never runs, it follows break", word.Repr())
+		}
+		if word.Type != P_SYMBOL {
+			continue
+		}
+		name := word.Value.(string)
+		if is_word(word, "loop") && ix > 0 && list.words[ix-1].Type == P_BLOCK {
+			if !v.has_break(c.parse(list.words[ix-1].Value.(IBlock)), map[string]bool{}) {
+				c.report(word.Pos, "[LOOP] the loop has no reachable break, only an error ends it")
+			}
+		}
+		if is_word(word, "def") && ix > 0 && list.words[ix-1].Type == P_SYMBOL {
+			if defined := list.words[ix-1].Value.(string); is_builtin(defined) {
+				c.report(list.words[ix-1].Pos, "[SHADOW] %v is a builtin, def cannot redefine it, use override", defined)
+			}
+		}
+		if v.targets[word.Pos] && is_builtin(name) {
+			c.report(word.Pos, "[SHADOW] the variable %v has the name of a builtin, %v runs the builtin instead", name, name)
+		}
+		if !word.Quoted && !v.targets[word.Pos] && !v.bound(name) {
+			message := fmt.Sprintf("[UNBOUND] %v is not a builtin, word or stored name, it pushes itself as a symbol", name)
+			if suggestion := v.closest(name); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %v?", suggestion)
+			}
+			c.report(word.Pos, "%v", message)
+		}
+	}
+}
+
+func is_builtin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// bound is true for names that do something else than push themselves
+func (v *vetter) bound(name string) bool {
+	root, _, _ := strings.Cut(name, ".")
+	_, is_word := v.checker.words[name]
+	return Contains(name, ":", ";") || is_builtin(name) || is_word || v.names[root]
+}
+
+// has_break finds a break that leaves the loop running words, breaks in
+// nested loops leave those instead
+func (v *vetter) has_break(words []PWord, visited map[string]bool) bool {
+	for ix, word := range words {
+		if is_word(word, "break") {
+			return true
+		}
+		if word.Type == P_BLOCK && !(ix+1 < len(words) && is_word(words[ix+1], "loop")) {
+			if v.has_break(v.checker.parse(word.Value.(IBlock)), visited) {
+				return true
+			}
+		}
+		if word.Type == P_SYMBOL && !word.Quoted {
+			name := word.Value.(string)
+			if definition, ok := v.checker.words[name]; ok && !visited[name] {
+				visited[name] = true
+				if v.has_break(definition.body, visited) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// check_end reports values the program leaves on the stack, when the
+// checker can tell
+func (v *vetter) check_end() {
+	if len(v.words) == 0 {
+		return
+	}
+	c := new_checker(CheckOptions{})
+	c.words, c.parsed = v.checker.words, v.checker.parsed
+	end := &checkState{}
+	c.run_words(v.words, end)
+	if !end.lost && !end.ended && end.depth() > 0 {
+		last := v.words[len(v.words)-1]
+		v.checker.report(last.Pos, "[STACK] the program ends with %v on the stack", count(end.depth(), "item"))
+	}
+}
+
+// closest is a known name at most two edits away from name, if any
+func (v *vetter) closest(name string) string {
+	var candidates []string
+	for candidate := range builtins {
+		candidates = append(candidates, candidate)
+	}
+	for candidate := range v.checker.words {
+		candidates = append(candidates, candidate)
+	}
+	for candidate := range v.names {
+		candidates = append(candidates, candidate)
+	}
+	sort.Strings(candidates)
+	best, best_distance := "", 3
+	for _, candidate := range candidates {
+		if distance := edit_distance(name, candidate); distance < best_distance && distance < len([]rune(name)) {
+			best, best_distance = candidate, distance
+		}
+	}
+	return best
+}
+
+// edit_distance counts the insertions, deletions and replacements that
+// turn a into b
+func edit_distance(a string, b string) int {
+	first, second := []rune(a), []rune(b)
+	row := make([]int, len(second)+1)
+	for ix := range row {
+		row[ix] = ix
+	}
+	for ix := 1; ix <= len(first); ix++ {
+		previous := row[0]
+		row[0] = ix
+		for jx := 1; jx <= len(second); jx++ {
+			cost := 1
+			if first[ix-1] == second[jx-1] {
+				cost = 0
+			}
+			previous, row[jx] = row[jx], min(row[jx]+1, row[jx-1]+1, previous+cost)
+		}
+	}
+	return row[len(second)]
+}
+
+// run_vet vets the scripts in paths and prints what it finds, it returns
+// the exit code. A file another one imports is vetted as a module.
+func run_vet(paths []string) int {
+	files, err := find_files(paths, ".nm")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	status := 0
+	var vetters []*vetter
+	imported := map[string]bool{}
+	for _, path := range files {
+		v, err := load_vet(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		vetters = append(vetters, v)
+		for _, module := range v.imports {
+			imported[module] = true
+		}
+	}
+	for _, v := range vetters {
+		module := imported[strings.TrimSuffix(filepath.Base(v.path), ".nm")]
+		diagnostics, err := v.vet(VetOptions{Module: module})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		for _, diagnostic := range diagnostics {
+			fmt.Println(diagnostic)
+			status = 1
+		}
+	}
+	return status
+}
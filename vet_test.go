@@ -0,0 +1,59 @@
+package numen
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func vet_code(t *testing.T, code string, options VetOptions) []string {
+	t.Helper()
+	dir := write_files(t, map[string]string{"vet.nm": code})
+	diagnostics, err := VetFile(filepath.Join(dir, "vet.nm"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, diagnostic := range diagnostics {
+		messages = append(messages, diagnostic.Message)
+	}
+	return messages
+}
+
+func TestVet(t *testing.T) {
+	cases := []struct {
+		name string
+		code string
+		want []string // prefixes of the messages, in order
+	}{
+		{"annotated definition", ": sq ( a -- b ) dup * ; 3 sq println", nil},
+		{"annotated shadowing definition", ": dup ( a -- a a ) ; 1 dup", []string{"[SHADOW] dup is a builtin, :", "[STACK]"}},
+		{"shadowing def", "{ 1 } 'swap def", []string{"[SHADOW] swap is a builtin, def"}},
+		{"variable named like a builtin", "1 'drop store", []string{"[SHADOW] the variable drop", "[UNUSED] drop"}},
+		{"unused store", "1 'x store 2 'y store y println", []string{"[UNUSED] x is stored"}},
+		{"store loaded in a block", "1 'x store { x println } run", nil},
+		{"typo", "1 'count store cuont println", []string{"[UNUSED] count", "[UNBOUND] cuont is not"}},
+		{"code after break", "{ break 1 } loop", []string{"[UNREACHABLE] 1 never runs"}},
+		{"loop without break", "{ 1 drop } loop", []string{"[LOOP]"}},
+		{"leftover values", "1 2", []string{"[STACK] the program ends with 2 items"}},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			got := vet_code(t, test.code, VetOptions{})
+			if len(got) != len(test.want) {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+			for ix, message := range got {
+				if !strings.HasPrefix(message, test.want[ix]) {
+					t.Errorf("message %v is %q, want it to start with %q", ix, message, test.want[ix])
+				}
+			}
+		})
+	}
+}
+
+func TestVetModuleExports(t *testing.T) {
+	if got := vet_code(t, "1 'pi store", VetOptions{Module: true}); len(got) != 0 {
+		t.Errorf("a module's top level stores are exports, got %q", got)
+	}
+}
@@ -0,0 +1,147 @@
+package numen
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// userWords is the dictionary of words defined with : ... ; or def. Each
+// name keeps its older definitions so forget can bring them back.
+var userWords = map[string][]IBlock{}
+
+// overriding counts the running overrides of each builtin, inside its own
+// body an override reaches the builtin it replaces
+var overriding = map[string]int{}
+
+// dictionary is the dictionary of the running code: the one of the module
+// it belongs to, or userWords for the main program
+func dictionary() map[string][]IBlock {
+	for scope := currentScope; scope != nil; scope = scope.parent {
+		if scope.words != nil {
+			return scope.words
+		}
+	}
+	return userWords
+}
+
+// find_word returns the latest definition of a user word
+func find_word(name string) (IBlock, bool) {
+	definitions := dictionary()[name]
+	if len(definitions) == 0 || overriding[name] > 0 {
+		return IBlock{}, false
+	}
+	return definitions[len(definitions)-1], true
+}
+
+func run_word(name string, block IBlock) {
+	if _, is_builtin := builtins[name]; is_builtin {
+		overriding[name]++
+		defer func() {
+			overriding[name]--
+		}()
+	}
+	if interp.Profiler != nil {
+		interp.Profiler.name_next(name)
+	}
+	run_block(block)
+}
+
+// define_word adds a definition on top of any earlier one. Builtins can only
+// be replaced when override is set.
+func define_word(name string, block IBlock, override bool, op string) {
+	if _, is_builtin := builtins[name]; is_builtin && !override {
+		panicf("[%v] %v is a builtin word, use override to replace it", op, name)
+	}
+	words := dictionary()
+	words[name] = append(words[name], block)
+}
+
+// Repr writes the word back as source, only quoted symbols keep a quote
+func (word PWord) Repr() string {
+	if word.Type == P_SYMBOL && !word.Quoted {
+		return word.Value.(string)
+	}
+	return word.PToken.Repr()
+}
+
+// read_definition reads the rest of a : name ... ; definition from the
+// parser and adds it to the dictionary.
+func read_definition(interp_chan chan PWord, scope *IScope) {
+	name_word, ok := <-interp_chan
+	if !ok || name_word.Type != P_SYMBOL || name_word.Quoted {
+		panicf("[:] expected a word name after :")
+	}
+	name := name_word.Value.(string)
+	var body strings.Builder
+	var body_pos, end Position
+	first := true
+	for {
+		word, ok := <-interp_chan
+		if !ok {
+			panicf("[:] definition of %v never closed, might be a missing ';'", name)
+		}
+		if word.Type == P_SYMBOL && !word.Quoted {
+			if word.Value == ";" {
+				break
+			} else if word.Value == ":" {
+				panicf("[:] cannot nest definitions, %v is missing a ';'", name)
+			}
+		}
+		if first {
+			first = false
+			if _, ok := effect_annotation(word.PToken); ok {
+				// a ( a b -- c ) stack effect comment, only numen check reads it
+				continue
+			}
+		}
+		source := word.Repr()
+		if body.Len() == 0 {
+			body_pos = word.Pos
+		} else {
+			layout(&body, end, word.Pos)
+		}
+		body.WriteString(source)
+		end = Position{word.Pos.File, word.Pos.Line, word.Pos.Col + utf8.RuneCountInString(source)}
+	}
+	define_word(name, IBlock{Code: body.String(), Env: scope, Pos: body_pos}, false, ":")
+}
+
+// layout writes the space between two words so the body parses back with
+// the words at their original lines and columns
+func layout(body *strings.Builder, end Position, next Position) {
+	if next.Line > end.Line {
+		body.WriteString(strings.Repeat("\n", next.Line-end.Line))
+		body.WriteString(strings.Repeat(" ", max(next.Col-1, 0)))
+	} else {
+		body.WriteString(strings.Repeat(" ", max(next.Col-end.Col, 1)))
+	}
+}
+
+var wordBuiltins = map[string]func(){
+	"def": func() {
+		// Syntax: { dup * } 'square def
+		name := globalStack.PopString()
+		block := globalStack.PopBlock()
+		define_word(name, block, false, "DEF")
+	},
+	"override": func() {
+		// Syntax: { ... } 'dup override, replaces a builtin on purpose
+		name := globalStack.PopString()
+		block := globalStack.PopBlock()
+		define_word(name, block, true, "OVERRIDE")
+	},
+	"forget": func() {
+		// Syntax: 'square forget, drops the latest definition
+		name := globalStack.PopString()
+		words := dictionary()
+		definitions := words[name]
+		if len(definitions) == 0 {
+			panicf("[FORGET] %v is not a user word", name)
+		}
+		if len(definitions) == 1 {
+			delete(words, name)
+		} else {
+			words[name] = definitions[:len(definitions)-1]
+		}
+	},
+}